@@ -6,6 +6,7 @@ MERGED_FILE=tables/merged.csv
 OUTPUT=/classification.json
 DATASET_FOLDER_SUFFIX=_dataset
 DATASETS=(26_radon_seed 32_wikiqa 60_jester 185_baseball 196_autoMpg 313_spectrometer 38_sick 1491_one_hundred_plants_margin 27_wordLevels 57_hypothyroid 299_libras_move 534_cps_85_wages 1567_poker_hand 22_handgeometry)
+SKIPPED=()
 REST_ENDPOINT=HTTP://localhost:5000
 CLASSIFICATION_FUNCTION=fileUpload
 
@@ -20,6 +21,13 @@ do
     echo "--------------------------------------------------------------------------------"
     echo " Classifying $DATASET dataset"
     echo "--------------------------------------------------------------------------------"
+    # only the errors of the schema fail the validation, the warnings are logged
+    if ! go run cmd/distil-validate/main.go \
+        --schema="$DATA_DIR/${DATASET}/TRAIN/dataset_TRAIN/$SCHEMA"; then
+        echo "Skipping $DATASET dataset, its schema is invalid"
+        SKIPPED+=("$DATASET")
+        continue
+    fi
     go run cmd/distil-classify/main.go \
         --rest-endpoint="$REST_ENDPOINT" \
         --classification-function="$CLASSIFICATION_FUNCTION" \
@@ -29,3 +37,8 @@ done
 
 # stop classification REST API container
 docker stop classification_rest
+
+if [ ${#SKIPPED[@]} -gt 0 ]; then
+    echo "Skipped ${#SKIPPED[@]} datasets with an invalid schema: ${SKIPPED[*]}"
+    exit 1
+fi
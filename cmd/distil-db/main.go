@@ -0,0 +1,217 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"text/tabwriter"
+
+	"github.com/pkg/errors"
+	log "github.com/unchartedsoftware/plog"
+	"github.com/urfave/cli"
+
+	"github.com/uncharted-distil/distil-ingest/conf"
+	"github.com/uncharted-distil/distil-ingest/postgres"
+)
+
+func main() {
+
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	app := cli.NewApp()
+	app.Name = "distil-db"
+	app.Version = "0.1.0"
+	app.Usage = "Manage the datasets ingested into postgres"
+	app.UsageText = "distil-db --database=<name> <command> [arguments]"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "database",
+			Value: "",
+			Usage: "The postgres database to use",
+		},
+		cli.StringFlag{
+			Name:  "db-host",
+			Value: "localhost",
+			Usage: "The postgres database hostname - defaults to localhost",
+		},
+		cli.IntFlag{
+			Name:  "db-port",
+			Value: 5432,
+			Usage: "The postgres database port - defaults to 5432",
+		},
+		cli.StringFlag{
+			Name:  "db-user",
+			Value: "",
+			Usage: "The database user to use.",
+		},
+		cli.StringFlag{
+			Name:  "db-password",
+			Value: "",
+			Usage: "The database password to use for authentication.",
+		},
+	}
+	app.Commands = []cli.Command{
+		{
+			Name:      "list",
+			Usage:     "List the ingested datasets with their row and variable counts",
+			ArgsUsage: " ",
+			Action:    withDatabase(list),
+		},
+		{
+			Name:      "inspect",
+			Usage:     "Show the variables stored for a dataset",
+			ArgsUsage: "<dataset>",
+			Action:    withDatabase(inspect),
+		},
+		{
+			Name:      "delete",
+			Usage:     "Delete the view, base, result and variable tables of a dataset",
+			ArgsUsage: "<dataset>",
+			Action:    withDatabase(remove),
+		},
+		{
+			Name:      "rename",
+			Usage:     "Rename the view, base, result and variable tables of a dataset",
+			ArgsUsage: "<dataset> <new-name>",
+			Action:    withDatabase(rename),
+		},
+	}
+	// run app
+	app.Run(os.Args)
+}
+
+// withDatabase connects to the database set by the global flags before
+// running the command.
+func withDatabase(command func(*cli.Context, *postgres.Database) error) func(*cli.Context) error {
+	return func(c *cli.Context) error {
+		if c.GlobalString("database") == "" {
+			return cli.NewExitError("missing commandline flag `--database`", 1)
+		}
+
+		config := &conf.Conf{
+			Database:   c.GlobalString("database"),
+			DBHost:     c.GlobalString("db-host"),
+			DBPort:     c.GlobalInt("db-port"),
+			DBUser:     c.GlobalString("db-user"),
+			DBPassword: c.GlobalString("db-password"),
+		}
+
+		pg, err := postgres.NewDatabase(config)
+		if err != nil {
+			log.Errorf("%v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+		defer pg.DB.Close()
+
+		err = command(c, pg)
+		if err != nil {
+			log.Errorf("%v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+
+		return nil
+	}
+}
+
+func list(c *cli.Context, pg *postgres.Database) error {
+	datasets, err := pg.ListDatasets()
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tROWS\tVARIABLES")
+	for _, ds := range datasets {
+		fmt.Fprintf(w, "%s\t%d\t%d\n", ds.Name, ds.Rows, ds.Variables)
+	}
+
+	return w.Flush()
+}
+
+func inspect(c *cli.Context, pg *postgres.Database) error {
+	name, err := existingDataset(c, pg, 1)
+	if err != nil {
+		return err
+	}
+
+	rows, err := pg.CountRows(name)
+	if err != nil {
+		return err
+	}
+	variables, err := pg.FetchVariables(name)
+	if err != nil {
+		return err
+	}
+
+	stats, err := pg.FetchDatasetStats(name)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Dataset %s: %d rows, %d variables\n", name, rows, len(variables))
+	if stats != nil {
+		fmt.Printf("Source: %d rows, %d columns, %d malformed rows, %s encoding\n", stats.NumRows, stats.NumColumns, stats.NumMalformedRows, stats.Encoding)
+	}
+	fmt.Println()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tROLE\tTYPE")
+	for _, v := range variables {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", v.Name, v.Role, v.Type)
+	}
+
+	return w.Flush()
+}
+
+func remove(c *cli.Context, pg *postgres.Database) error {
+	name, err := existingDataset(c, pg, 1)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Deleting dataset %s", name)
+
+	return pg.DeleteDataset(name)
+}
+
+func rename(c *cli.Context, pg *postgres.Database) error {
+	name, err := existingDataset(c, pg, 2)
+	if err != nil {
+		return err
+	}
+
+	return pg.RenameDataset(name, c.Args().Get(1))
+}
+
+// existingDataset reads the dataset name from the command arguments and
+// makes sure the dataset is stored in the database.
+func existingDataset(c *cli.Context, pg *postgres.Database, argCount int) (string, error) {
+	if c.NArg() != argCount {
+		return "", errors.Errorf("expected %d arguments but received %d", argCount, c.NArg())
+	}
+
+	name := c.Args().First()
+	exists, err := pg.DatasetExists(name)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", errors.Errorf("dataset %s does not exist", name)
+	}
+
+	return name, nil
+}
@@ -26,6 +26,7 @@ import (
 	"github.com/urfave/cli"
 
 	"github.com/uncharted-distil/distil-compute/primitive/compute"
+	"github.com/uncharted-distil/distil-ingest/metadata"
 	"github.com/uncharted-distil/distil-ingest/primitive"
 )
 
@@ -85,11 +86,20 @@ func main() {
 			Name:  "has-header",
 			Usage: "Whether or not the CSV file has a header row",
 		},
+		cli.BoolFlag{
+			Name:  "collect-unknown-resources",
+			Usage: "Load resources of an unknown type as generic collections instead of failing",
+		},
 		cli.Float64Flag{
 			Name:  "threshold",
 			Value: 0.2,
 			Usage: "Confidence threshold to use for labels",
 		},
+		cli.StringFlag{
+			Name:  "schema-version",
+			Value: "",
+			Usage: "The version of the output schema, the version of the input schema if empty",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
 		if c.String("endpoint") == "" {
@@ -116,7 +126,11 @@ func main() {
 			log.Errorf("%v", err)
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
-		step := primitive.NewIngestStep(client)
+		step := primitive.NewIngestStep(client, metadata.CollectUnknownResources(c.Bool("collect-unknown-resources")))
+		err = step.SetSchemaVersion(c.String("schema-version"))
+		if err != nil {
+			return cli.NewExitError(errors.Cause(err), 1)
+		}
 
 		// create featurizer
 		err = step.Featurize(schemaPath, datasetPath, rootDataPath, outputPath, hasHeader)
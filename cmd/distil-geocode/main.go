@@ -86,6 +86,11 @@ func main() {
 			Name:  "has-header",
 			Usage: "Whether or not the CSV file has a header row",
 		},
+		cli.StringFlag{
+			Name:  "schema-version",
+			Value: "",
+			Usage: "The version of the output schema, the version of the input schema if empty",
+		},
 	}
 	app.Action = func(c *cli.Context) error {
 		if c.String("endpoint") == "" {
@@ -111,6 +116,10 @@ func main() {
 			return cli.NewExitError(errors.Cause(err), 2)
 		}
 		step := primitive.NewIngestStep(client)
+		err = step.SetSchemaVersion(c.String("schema-version"))
+		if err != nil {
+			return cli.NewExitError(errors.Cause(err), 1)
+		}
 
 		// geocode the file
 		err = step.GeocodeForwardUpdate(schemaPath, classificationPath, datasetPath, rootDataPath, outputPath, hasHeader)
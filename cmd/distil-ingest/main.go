@@ -17,13 +17,21 @@ package main
 
 import (
 	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"os"
 	"path/filepath"
+	"reflect"
 	"runtime"
+	"strconv"
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/unchartedsoftware/deluge"
 	delugeElastic "github.com/unchartedsoftware/deluge/elastic/v5"
 	"github.com/urfave/cli"
@@ -32,6 +40,7 @@ import (
 	"github.com/uncharted-distil/distil-compute/model"
 	"github.com/uncharted-distil/distil-ingest/conf"
 	"github.com/uncharted-distil/distil-ingest/document/d3mdata"
+	"github.com/uncharted-distil/distil-ingest/es"
 	"github.com/uncharted-distil/distil-ingest/metadata"
 	"github.com/uncharted-distil/distil-ingest/postgres"
 	log "github.com/unchartedsoftware/plog"
@@ -42,6 +51,7 @@ const (
 	errSampleSize            = 10
 	metadataIndexName        = "datasets"
 	typeSourceClassification = "classification"
+	metadataSwapAttempts     = 3
 )
 
 func main() {
@@ -107,13 +117,23 @@ func main() {
 		cli.StringFlag{
 			Name:  "es-data-index",
 			Value: "",
-			Usage: "The Elasticsearch index to ingest data into",
+			Usage: "The Elasticsearch index to ingest the rows into, the rows are not ingested into Elasticsearch if empty",
 		},
 		cli.StringFlag{
 			Name:  "es-dataset-prefix",
 			Value: "",
 			Usage: "The Elasticsearch prefix to use for dataset ids",
 		},
+		cli.StringFlag{
+			Name:  "es-metadata-template",
+			Value: "",
+			Usage: "The JSON file holding the `settings` and document `properties` of the metadata index, replacing the default analyzers and mappings",
+		},
+		cli.IntFlag{
+			Name:  "es-retention",
+			Value: 2,
+			Usage: "The number of generations of the Elasticsearch metadata and data indices to keep, including the current one",
+		},
 		cli.StringFlag{
 			Name:  "database",
 			Value: "",
@@ -149,6 +169,34 @@ func main() {
 			Value: 1000,
 			Usage: "The bulk batch size for database ingest",
 		},
+		cli.StringFlag{
+			Name:  "db-loader",
+			Value: postgres.InsertLoader,
+			Usage: "The database loader to use, either `insert` or `copy`",
+		},
+		cli.StringFlag{
+			Name:  "db-reject-file",
+			Value: "",
+			Usage: "The CSV file to write rows rejected by the database ingest to - defaults to the dataset folder name with a `.rejects.csv` suffix in the working directory",
+		},
+		cli.BoolFlag{
+			Name:  "db-typed-storage",
+			Usage: "Store variables using their types in the base table, recording values which cannot be converted",
+		},
+		cli.StringFlag{
+			Name:  "db-indexes",
+			Value: "all",
+			Usage: "The comma separated kinds of indexes to create after ingest: `key`, `categorical`, `numerical`, `text`, `all` or `none`",
+		},
+		cli.StringFlag{
+			Name:  "db-search",
+			Value: "none",
+			Usage: "The generated search columns to add to the base table: `none`, `column` per text variable or a combined `row` column",
+		},
+		cli.BoolFlag{
+			Name:  "db-geography",
+			Usage: "Store the geocoded latitude and longitude pairs as PostGIS geography points (requires PostgreSQL 12)",
+		},
 		cli.Int64Flag{
 			Name:  "batch-size",
 			Value: 1024 * 1024 * 20,
@@ -167,6 +215,14 @@ func main() {
 			Name:  "metadata-only",
 			Usage: "Create the basic Postgres tables",
 		},
+		cli.BoolFlag{
+			Name:  "append",
+			Usage: "Append new rows to an existing Postgres dataset",
+		},
+		cli.BoolFlag{
+			Name:  "add-variables",
+			Usage: "Add new variables to an existing Postgres dataset, backfilling their values by d3mIndex",
+		},
 		cli.IntFlag{
 			Name:  "num-workers",
 			Value: 8,
@@ -202,6 +258,12 @@ func main() {
 		if c.String("dataset") == "" {
 			return cli.NewExitError("missing commandline flag `--dataset`", 1)
 		}
+		if c.Bool("append") && c.Bool("clear-existing") {
+			return cli.NewExitError("commandline flags `--append` and `--clear-existing` are mutually exclusive", 1)
+		}
+		if c.Bool("add-variables") && (c.Bool("append") || c.Bool("clear-existing")) {
+			return cli.NewExitError("commandline flag `--add-variables` cannot be used with `--append` or `--clear-existing`", 1)
+		}
 		if c.String("dataset-folder") == "" {
 			return cli.NewExitError("missing commandline flag `--dataset-folder`", 1)
 		}
@@ -222,6 +284,8 @@ func main() {
 			ESEndpoint:           c.String("es-endpoint"),
 			ESIndex:              c.String("es-data-index"),
 			ESDatasetPrefix:      c.String("es-dataset-prefix"),
+			ESRetention:          c.Int("es-retention"),
+			ESMetadataTemplate:   c.String("es-metadata-template"),
 			TypeSource:           c.String("type-source"),
 			DatasetFolder:        c.String("dataset-folder"),
 			ClassificationPath:   filepath.Clean(c.String("classification")),
@@ -238,6 +302,8 @@ func main() {
 			ScanBufferSize:       c.Int("scan-size"),
 			ClearExisting:        c.Bool("clear-existing"),
 			MetadataOnly:         c.Bool("metadata-only"),
+			Append:               c.Bool("append"),
+			AddVariables:         c.Bool("add-variables"),
 			Database:             c.String("database"),
 			DBTable:              c.String("db-table"),
 			DBUser:               c.String("db-user"),
@@ -245,6 +311,17 @@ func main() {
 			DBBatchSize:          c.Int("db-batch-size"),
 			DBHost:               c.String("db-host"),
 			DBPort:               c.Int("db-port"),
+			DBLoader:             c.String("db-loader"),
+			DBRejectPath:         c.String("db-reject-file"),
+			DBTypedStorage:       c.Bool("db-typed-storage"),
+			DBIndexes:            c.String("db-indexes"),
+			DBSearch:             c.String("db-search"),
+			DBGeography:          c.Bool("db-geography"),
+		}
+
+		// the input dataset folder is left untouched.
+		if config.DBRejectPath == "" {
+			config.DBRejectPath = fmt.Sprintf("%s.rejects.csv", filepath.Base(config.DatasetFolder))
 		}
 
 		metadata.SetTypeProbabilityThreshold(config.ProbabilityThreshold)
@@ -295,11 +372,18 @@ func main() {
 			//os.Exit(1)
 		}
 
-		// load stats
-		err = metadata.LoadDatasetStats(meta, config.DatasetPath)
-		if err != nil {
-			log.Error(err)
-			os.Exit(1)
+		// load the stats of the dataset and its variables, which are only
+		// needed when the rows are ingested.
+		var profile *metadata.DatasetProfile
+		if !config.MetadataOnly {
+			profile, err = metadata.ProfileDataset(meta, config.DatasetPath, metadata.HeaderRowCount(meta))
+			if err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+			if profile.Dataset.NumMalformedRows > 0 {
+				log.Warnf("%d of %d rows are malformed", profile.Dataset.NumMalformedRows, profile.Dataset.NumRows)
+			}
 		}
 
 		if config.ESEndpoint != "" && !config.MetadataOnly {
@@ -315,16 +399,45 @@ func main() {
 				os.Exit(1)
 			}
 
+			// select the index handling of the cluster version
+			cluster, err := es.DetectCluster(elasticClient, config.ESEndpoint)
+			if err != nil {
+				log.Error(err)
+				os.Exit(1)
+			}
+
+			// replace the indices created before they were versioned by
+			// aliases to a copy of them
+			for _, name := range []string{c.String("es-metadata-index"), config.ESIndex} {
+				if name == "" {
+					continue
+				}
+				err = es.MigrateToAlias(cluster, name)
+				if err != nil {
+					log.Error(err)
+					os.Exit(1)
+				}
+			}
+
 			// ingest the metadata
-			err = ingestMetadata(metadataIndexName, config.ESDatasetPrefix, meta, elasticClient)
+			err = ingestMetadata(config, c.String("es-metadata-index"), config.ESDatasetPrefix, meta, profile, cluster)
 			if err != nil {
 				log.Error(err)
 				os.Exit(1)
 			}
+
+			// ingest the rows as documents
+			if config.ESIndex != "" {
+				err = ingestES(config, meta, cluster)
+				if err != nil {
+					log.Error(err)
+					os.Exit(1)
+				}
+			}
 		}
 
 		if config.Database != "" {
-			err := ingestPostgres(config, meta)
+			err := ingestPostgres(config, meta, profile)
 			if err != nil {
 				log.Error(err)
 				os.Exit(1)
@@ -337,60 +450,395 @@ func main() {
 	app.Run(os.Args)
 }
 
-func ingestMetadata(metadataIndexName string, datasetPrefix string, meta *model.Metadata, elasticClient *elastic.Client) error {
-	// Create the metadata index if it doesn't exist
-	err := metadata.CreateMetadataIndex(elasticClient, metadataIndexName, false)
+// ingestMetadata writes the metadata of the dataset to a new generation of
+// the metadata index, holding a copy of the datasets of the current one, then
+// points the metadata alias to it. A generation copied before a concurrent
+// ingest swapped the alias would drop that ingest's dataset, so it is
+// discarded and the copy is retried.
+func ingestMetadata(config *conf.Conf, metadataIndexName string, datasetPrefix string, meta *model.Metadata, profile *metadata.DatasetProfile, cluster es.Cluster) error {
+	var template *metadata.IndexTemplate
+	if config.ESMetadataTemplate != "" {
+		var err error
+		template, err = metadata.LoadIndexTemplate(config.ESMetadataTemplate)
+		if err != nil {
+			return err
+		}
+	}
+
+	for attempt := 1; attempt <= metadataSwapAttempts; attempt++ {
+		generation, err := ingestMetadataGeneration(metadataIndexName, datasetPrefix, meta, profile, cluster, template)
+		if err != nil {
+			return err
+		}
+
+		// make sure the copied generation is still the current one
+		current, err := es.AliasIndices(cluster.Client(), metadataIndexName)
+		if err != nil {
+			es.DeleteIndex(cluster.Client(), generation.index)
+			return err
+		}
+		if !reflect.DeepEqual(current, generation.previous) {
+			log.Warnf("Metadata index %s changed while ingesting, retrying (attempt %d of %d)", metadataIndexName, attempt, metadataSwapAttempts)
+			es.DeleteIndex(cluster.Client(), generation.index)
+			continue
+		}
+
+		return swapGeneration(config, cluster.Client(), metadataIndexName, generation.index)
+	}
+
+	return fmt.Errorf("metadata index %s kept changing while ingesting, giving up after %d attempts", metadataIndexName, metadataSwapAttempts)
+}
+
+// metadataGeneration is a new generation of the metadata index, along with
+// the indices the alias pointed to when it was copied.
+type metadataGeneration struct {
+	index    string
+	previous []string
+}
+
+// ingestMetadataGeneration creates a new generation of the metadata index,
+// copies the datasets of the current generation into it and writes the
+// dataset to it.
+func ingestMetadataGeneration(metadataIndexName string, datasetPrefix string, meta *model.Metadata, profile *metadata.DatasetProfile, cluster es.Cluster, template *metadata.IndexTemplate) (*metadataGeneration, error) {
+	client := cluster.Client()
+	generation := &metadataGeneration{
+		index: es.NewGeneration(metadataIndexName),
+	}
+
+	previous, err := es.AliasIndices(client, metadataIndexName)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	generation.previous = previous
+
+	err = metadata.CreateMetadataIndex(cluster, generation.index, template, true)
+	if err != nil {
+		return nil, err
+	}
+
+	// copy the datasets of the current generation
+	var previousCount int64
+	if len(previous) > 0 {
+		previousCount, err = es.CountDocuments(client, metadataIndexName)
+		if err != nil {
+			es.DeleteIndex(client, generation.index)
+			return nil, err
+		}
+		_, err = client.Reindex().
+			SourceIndex(metadataIndexName).
+			DestinationIndex(generation.index).
+			Script(metadata.TypedNameScript()).
+			Refresh("true").
+			Do(context.Background())
+		if err != nil {
+			es.DeleteIndex(client, generation.index)
+			return nil, errors.Wrapf(err, "failed to copy metadata index %s to %s", metadataIndexName, generation.index)
+		}
+	}
+
+	// Ingest the dataset info into the new generation
+	err = metadata.IngestMetadata(cluster, generation.index, datasetPrefix, metadata.Seed, meta, profile)
+	if err != nil {
+		es.DeleteIndex(client, generation.index)
+		return nil, err
 	}
 
-	// Ingest the dataset info into the metadata index
-	err = metadata.IngestMetadata(elasticClient, metadataIndexName, datasetPrefix, metadata.Seed, meta)
+	// Make sure no dataset was lost in the copy.
+	count, err := es.CountDocuments(client, generation.index)
+	if err != nil {
+		es.DeleteIndex(client, generation.index)
+		return nil, err
+	}
+	if count < previousCount || count == 0 {
+		es.DeleteIndex(client, generation.index)
+		return nil, fmt.Errorf("metadata index %s holds %d datasets but its new generation %s holds %d", metadataIndexName, previousCount, generation.index, count)
+	}
+
+	return generation, nil
+}
+
+// swapGeneration points the alias to its new generation and deletes the
+// generations past the retention.
+func swapGeneration(config *conf.Conf, elasticClient *elastic.Client, alias string, generation string) error {
+	err := es.SwapAlias(elasticClient, alias, generation)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return es.PruneGenerations(elasticClient, alias, config.ESRetention)
 }
 
-func ingestES(config *conf.Conf, delugeClient *delugeElastic.Client, meta *model.Metadata) error {
-	input, err := deluge.NewFileInput([]string{config.DatasetPath}, nil)
+// ingestES writes the rows to a new generation of the data index, then
+// points the data alias to it once all ingested documents are counted.
+func ingestES(config *conf.Conf, meta *model.Metadata, cluster es.Cluster) error {
+	log.Info("Starting document ingestion")
+	elasticClient := cluster.Client()
+
+	// the documents are written a line at a time so the header rows need to
+	// be removed beforehand.
+	dataPath, rowCount, err := stripHeaderRows(config.DatasetPath, metadata.HeaderRowCount(meta))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dataPath)
+
+	stats := d3mdata.NewParseStats()
+	doc, err := d3mdata.NewD3MData(meta, stats)
 	if err != nil {
 		return err
 	}
 
-	doc, err := d3mdata.NewD3MData(meta)
+	// deluge writes the documents with a mapping type, so the typeless
+	// versions are sent the bulk requests directly.
+	generation := es.NewGeneration(config.ESIndex)
+	var docErrs []error
+	var ingestErr error
+	if cluster.SupportsMappingTypes() {
+		docErrs, ingestErr = ingestDeluge(config, doc, dataPath, generation)
+	} else {
+		docErrs, ingestErr = ingestBulk(config, cluster, doc, dataPath, generation)
+	}
+
+	// report the documents which failed, even if the ingest was aborted.
+	if len(docErrs) > 0 {
+		log.Errorf("Failed to ingest %d of %d documents, here is a sample of the errors:", len(docErrs), rowCount)
+		sample := docErrs
+		if len(sample) > errSampleSize {
+			sample = sample[:errSampleSize]
+		}
+		for _, docErr := range sample {
+			log.Errorf("%v", docErr)
+		}
+	}
+	if ingestErr != nil {
+		es.DeleteIndex(elasticClient, generation)
+		return ingestErr
+	}
+	log.Infof("Ingested %d of %d documents into %s", rowCount-len(docErrs), rowCount, generation)
+
+	failures := stats.Failures()
+	for _, name := range stats.FailedVariables() {
+		log.Warnf("Unable to parse %d values of variable %s, the values were left empty", failures[name], name)
+	}
+
+	if rowCount > 0 && float64(len(docErrs))/float64(rowCount) > config.ErrThreshold {
+		es.DeleteIndex(elasticClient, generation)
+		return fmt.Errorf("failed to ingest %d of %d documents, exceeding the error threshold of %v", len(docErrs), rowCount, config.ErrThreshold)
+	}
+
+	// Make sure every ingested document made it to the index.
+	count, err := es.CountDocuments(elasticClient, generation)
 	if err != nil {
 		return err
 	}
+	if count != int64(rowCount-len(docErrs)) {
+		es.DeleteIndex(elasticClient, generation)
+		return fmt.Errorf("ingested %d documents but index %s holds %d documents", rowCount-len(docErrs), generation, count)
+	}
+
+	return swapGeneration(config, elasticClient, config.ESIndex, generation)
+}
+
+// ingestDeluge writes the rows of the headerless dataset file to the index
+// with deluge, returning the errors of the documents which failed.
+func ingestDeluge(config *conf.Conf, doc deluge.Constructor, dataPath string, index string) ([]error, error) {
+	// create deluge client
+	delugeClient, err := delugeElastic.NewClient(
+		delugeElastic.SetURL(config.ESEndpoint),
+		delugeElastic.SetHttpClient(&http.Client{Timeout: timeout}),
+		delugeElastic.SetMaxRetries(10),
+		delugeElastic.SetSniff(false),
+		delugeElastic.SetGzip(true))
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := deluge.NewFileInput([]string{dataPath}, nil)
+	if err != nil {
+		return nil, err
+	}
 
 	// create ingestor
 	ingestor, err := deluge.NewIngestor(
 		deluge.SetDocument(doc),
 		deluge.SetInput(input),
 		deluge.SetClient(delugeClient),
-		deluge.SetIndex(config.ESIndex),
+		deluge.SetIndex(index),
 		deluge.SetErrorThreshold(config.ErrThreshold),
 		deluge.SetActiveConnections(config.NumActiveConnections),
 		deluge.SetNumWorkers(config.NumWorkers),
 		deluge.SetBulkByteSize(config.BulkByteSize),
 		deluge.SetScanBufferSize(config.ScanBufferSize),
-		deluge.ClearExistingIndex(config.ClearExisting),
+		deluge.ClearExistingIndex(true),
 		deluge.SetNumReplicas(1))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// ingest
 	err = ingestor.Ingest()
+
+	return deluge.DocErrs(), err
+}
+
+// ingestBulk writes the rows of the headerless dataset file to the index
+// with bulk requests of the cluster, holding up to the bulk byte size of
+// rows each, returning the errors of the documents which failed.
+func ingestBulk(config *conf.Conf, cluster es.Cluster, doc deluge.Constructor, dataPath string, index string) ([]error, error) {
+	document, err := doc()
 	if err != nil {
-		return err
+		return nil, err
+	}
+	docType, err := document.GetType()
+	if err != nil {
+		return nil, err
 	}
 
-	return nil
+	// the document mapping is keyed by its type
+	mapping, err := document.GetMapping()
+	if err != nil {
+		return nil, err
+	}
+	typedMappings := make(map[string]map[string]interface{})
+	err = json.Unmarshal([]byte(mapping), &typedMappings)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse document mapping")
+	}
+	properties, _ := typedMappings[docType]["properties"].(map[string]interface{})
+	settings := map[string]interface{}{
+		"number_of_replicas": 1,
+	}
+	err = cluster.CreateIndex(index, settings, cluster.Mappings(docType, properties))
+	if err != nil {
+		return nil, err
+	}
+
+	input, err := os.Open(dataPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to open headerless dataset")
+	}
+	defer input.Close()
+
+	var docErrs []error
+	var batch []*es.BulkDocument
+	batchSize := int64(0)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		errs, err := cluster.BulkIndex(index, docType, batch)
+		if err != nil {
+			return err
+		}
+		docErrs = append(docErrs, errs...)
+		batch = nil
+		batchSize = 0
+		return nil
+	}
+
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, config.ScanBufferSize), config.ScanBufferSize)
+	for scanner.Scan() {
+		line := scanner.Text()
+		bulkDoc, err := bulkDocument(document, line)
+		if err != nil {
+			docErrs = append(docErrs, err)
+			continue
+		}
+		batch = append(batch, bulkDoc)
+		batchSize += int64(len(line))
+		if batchSize >= config.BulkByteSize {
+			err = flush()
+			if err != nil {
+				return docErrs, err
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return docErrs, errors.Errorf("a row exceeds the scan size of %d bytes", config.ScanBufferSize)
+		}
+		return docErrs, errors.Wrap(err, "unable to read headerless dataset")
+	}
+
+	return docErrs, flush()
 }
 
-func ingestPostgres(config *conf.Conf, meta *model.Metadata) error {
+// bulkDocument parses a row into a document to store with a bulk request.
+func bulkDocument(document deluge.Document, line string) (*es.BulkDocument, error) {
+	err := document.SetData(line)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse row `%s`", line)
+	}
+	id, err := document.GetID()
+	if err != nil {
+		return nil, err
+	}
+	source, err := document.GetSource()
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to build document %s", id)
+	}
+
+	return &es.BulkDocument{
+		ID:     id,
+		Source: source,
+	}, nil
+}
+
+// stripHeaderRows copies the dataset file to a temporary file without its
+// header rows, returning the path of the copy and the number of data rows.
+func stripHeaderRows(path string, headerRows int) (string, int, error) {
+	input, err := os.Open(path)
+	if err != nil {
+		return "", 0, errors.Wrap(err, "unable to open dataset")
+	}
+	defer input.Close()
+
+	output, err := ioutil.TempFile("", "distil-ingest-*.csv")
+	if err != nil {
+		return "", 0, errors.Wrap(err, "unable to create headerless dataset")
+	}
+	defer output.Close()
+
+	reader := bufio.NewReader(input)
+	writer := bufio.NewWriter(output)
+	lineCount := 0
+	for {
+		line, err := reader.ReadString('\n')
+		if len(line) > 0 {
+			if lineCount >= headerRows {
+				_, writeErr := writer.WriteString(line)
+				if writeErr != nil {
+					os.Remove(output.Name())
+					return "", 0, errors.Wrap(writeErr, "unable to write headerless dataset")
+				}
+			}
+			lineCount = lineCount + 1
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			os.Remove(output.Name())
+			return "", 0, errors.Wrap(err, "unable to read dataset")
+		}
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		os.Remove(output.Name())
+		return "", 0, errors.Wrap(err, "unable to write headerless dataset")
+	}
+
+	rowCount := lineCount - headerRows
+	if rowCount < 0 {
+		rowCount = 0
+	}
+
+	return output.Name(), rowCount, nil
+}
+
+func ingestPostgres(config *conf.Conf, meta *model.Metadata, profile *metadata.DatasetProfile) error {
 	log.Info("Starting ingestion")
 
 	dbTableName := meta.StorageName
@@ -401,6 +849,30 @@ func ingestPostgres(config *conf.Conf, meta *model.Metadata) error {
 		return err
 	}
 
+	exists, err := pg.DatasetExists(dbTableName)
+	if err != nil {
+		return err
+	}
+
+	if (config.Append || config.AddVariables) && exists && !config.MetadataOnly {
+		// the staged rows are stored as the dataset was created so that they
+		// can be copied into it.
+		typed, err := pg.FetchTypedStorage(dbTableName)
+		if err != nil {
+			return err
+		}
+		if typed != pg.TypedStorage {
+			log.Warnf("Dataset %s was created with typed storage set to %t, which overrides the requested storage", dbTableName, typed)
+			pg.TypedStorage = typed
+		}
+	}
+	if config.Append && exists && !config.MetadataOnly {
+		return appendPostgres(config, meta, profile, pg, dbTableName)
+	}
+	if config.AddVariables && exists && !config.MetadataOnly {
+		return evolvePostgres(config, meta, profile, pg, dbTableName)
+	}
+
 	err = pg.CreateSolutionMetadataTables()
 	if err != nil {
 		return err
@@ -412,18 +884,146 @@ func ingestPostgres(config *conf.Conf, meta *model.Metadata) error {
 		return nil
 	}
 
-	// Drop the current table if requested.
-	if config.ClearExisting {
-		err = pg.DropView(dbTableName)
-		if err != nil {
-			log.Warn(err)
-		}
-		err = pg.DropTable(fmt.Sprintf("%s_base", dbTableName))
-		if err != nil {
-			log.Warn(err)
+	// Fail early if the dataset is present and is not to be replaced.
+	if exists && !config.ClearExisting {
+		return fmt.Errorf("dataset %s already exists", dbTableName)
+	}
+
+	// Load everything into staging tables so that the current version of
+	// the dataset remains intact until the new one is fully loaded.
+	stagingName, err := pg.BeginStaging(dbTableName)
+	if err != nil {
+		return err
+	}
+
+	// The indexes are built before the promotion so that the dataset is
+	// replaced along with them.
+	err = loadPostgres(config, meta, profile, pg, stagingName)
+	if err == nil {
+		err = pg.CreateIndexes(stagingName, meta.DataResources[0].Variables, pg.IndexPolicy)
+	}
+	if err != nil {
+		log.Warnf("Discarding staged dataset %s", stagingName)
+		pg.DiscardStaging(stagingName)
+		return err
+	}
+
+	err = pg.PromoteDataset(stagingName, dbTableName)
+	if err != nil {
+		pg.DiscardStaging(stagingName)
+		return err
+	}
+
+	log.Info("Done ingestion")
+
+	return nil
+}
+
+func appendPostgres(config *conf.Conf, meta *model.Metadata, profile *metadata.DatasetProfile, pg *postgres.Database, dbTableName string) error {
+	log.Infof("Appending to existing dataset %s", dbTableName)
+
+	// The incoming data must have the same variables as the stored dataset.
+	variables, err := pg.FetchVariableNames(dbTableName)
+	if err != nil {
+		return err
+	}
+	if !metadata.DatasetMatches(meta, variables) {
+		return fmt.Errorf("variables of dataset %s do not match the stored variables", dbTableName)
+	}
+
+	stagingName, err := pg.BeginStaging(dbTableName)
+	if err != nil {
+		return err
+	}
+
+	err = loadPostgres(config, meta, profile, pg, stagingName)
+	if err == nil {
+		var appended int
+		appended, err = pg.AppendDataset(stagingName, dbTableName)
+		if err == nil {
+			log.Infof("Appended %d new rows to dataset %s", appended, dbTableName)
 		}
 	}
+	if err != nil {
+		log.Warnf("Discarding staged dataset %s", stagingName)
+		pg.DiscardStaging(stagingName)
+		return err
+	}
+
+	// the existing indexes cover the appended rows.
+	err = pg.UpdateStatistics(dbTableName)
+	if err != nil {
+		return err
+	}
+
+	// the profile of the appended rows alone would replace the stats of
+	// the stored rows, the malformed rows adding up over the appends.
+	stored, err := pg.FetchDatasetStats(dbTableName)
+	if err != nil {
+		return err
+	}
+	appendedStats := profile.Dataset
+	profile, err = pg.ProfileDataset(dbTableName, meta.DataResources[0].Variables)
+	if err != nil {
+		return err
+	}
+	profile.Dataset.Encoding = appendedStats.Encoding
+	profile.Dataset.NumMalformedRows = appendedStats.NumMalformedRows
+	if stored != nil {
+		profile.Dataset.NumMalformedRows = profile.Dataset.NumMalformedRows + stored.NumMalformedRows
+	}
+	err = pg.StoreProfile(dbTableName, profile)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Done ingestion")
+
+	return nil
+}
+
+func evolvePostgres(config *conf.Conf, meta *model.Metadata, profile *metadata.DatasetProfile, pg *postgres.Database, dbTableName string) error {
+	log.Infof("Adding variables to existing dataset %s", dbTableName)
+
+	added, err := pg.NewVariables(dbTableName, meta)
+	if err != nil {
+		return err
+	}
+	for _, v := range added {
+		log.Infof("Adding variable %s", v.Name)
+	}
+
+	stagingName, err := pg.BeginStaging(dbTableName)
+	if err != nil {
+		return err
+	}
+
+	err = loadPostgres(config, meta, profile, pg, stagingName)
+	if err == nil {
+		err = pg.EvolveDataset(stagingName, dbTableName, added)
+	}
+	if err != nil {
+		log.Warnf("Discarding staged dataset %s", stagingName)
+		pg.DiscardStaging(stagingName)
+		return err
+	}
+
+	// the added variables are indexed as the rest of the dataset was.
+	policy, err := pg.FetchIndexPolicy(dbTableName)
+	if err != nil {
+		return err
+	}
+	err = pg.CreateIndexes(dbTableName, added, policy)
+	if err != nil {
+		return err
+	}
+
+	log.Info("Done ingestion")
 
+	return nil
+}
+
+func loadPostgres(config *conf.Conf, meta *model.Metadata, profile *metadata.DatasetProfile, pg *postgres.Database, dbTableName string) (err error) {
 	// Create the database table.
 	ds, err := pg.InitializeDataset(meta)
 	if err != nil {
@@ -440,6 +1040,10 @@ func ingestPostgres(config *conf.Conf, meta *model.Metadata) error {
 	if err != nil {
 		return err
 	}
+	err = pg.StoreProfile(dbTableName, profile)
+	if err != nil {
+		return err
+	}
 	log.Infof("Done storing metadata")
 
 	err = pg.CreateResultTable(dbTableName)
@@ -450,34 +1054,126 @@ func ingestPostgres(config *conf.Conf, meta *model.Metadata) error {
 
 	// Load the data.
 	reader, err := os.Open(config.DatasetPath)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
 	scanner := bufio.NewScanner(reader)
+	// long rows, such as those holding vectors, exceed the default size.
+	scanner.Buffer(make([]byte, config.ScanBufferSize), config.ScanBufferSize)
 
-	// skip header
-	scanner.Scan()
-	count := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		// Raw schema source will have header row.
-		if count > 0 || meta.SchemaSource != model.SchemaSourceRaw {
-			err = pg.AddWordStems(line)
-			if err != nil {
-				log.Warn(fmt.Sprintf("%v", err))
-			}
+	rejects := &rowRejects{
+		path: config.DBRejectPath,
+	}
+	defer func() {
+		closeErr := rejects.close()
+		if err == nil {
+			err = closeErr
+		}
+	}()
 
-			err = pg.IngestRow(dbTableName, line)
-			if err != nil {
-				log.Warn(fmt.Sprintf("%v", err))
+	// Read the rows on a separate goroutine while the workers ingest them.
+	rows := make(chan *postgres.Row, pg.NumWorkers*pg.BatchSize)
+	rowCount := 0
+	go func() {
+		defer close(rows)
+
+		// skip header
+		headerRows := metadata.HeaderRowCount(meta)
+		lineNumber := 0
+		for scanner.Scan() {
+			lineNumber = lineNumber + 1
+			if lineNumber > headerRows {
+				rowCount = rowCount + 1
+				rows <- &postgres.Row{
+					Number: lineNumber,
+					Data:   scanner.Text(),
+				}
 			}
 		}
-		count = count + 1
+	}()
+
+	ingested, err := pg.IngestRows(dbTableName, rows, func(row *postgres.Row, rowErr *postgres.RowError) error {
+		return rejects.add(row.Number, rowErr.Reason, row.Data)
+	})
+	if err != nil {
+		return err
+	}
+	err = scanner.Err()
+	if err == bufio.ErrTooLong {
+		return errors.Wrapf(err, "a row exceeds the scan size of %d bytes", config.ScanBufferSize)
+	}
+	if err != nil {
+		return err
 	}
 
 	err = pg.InsertRemainingRows()
 	if err != nil {
-		log.Warn(fmt.Sprintf("%v", err))
+		return err
 	}
 
-	log.Info("Done ingestion")
+	err = pg.BuildWordStems(dbTableName)
+	if err != nil {
+		return err
+	}
+
+	// Make sure every ingested row made it to the table.
+	stored, err := pg.CountRows(dbTableName)
+	if err != nil {
+		return err
+	}
+	if stored != ingested {
+		return fmt.Errorf("ingested %d rows but table %s holds %d rows", ingested, dbTableName, stored)
+	}
+
+	log.Infof("Read %d rows, loaded %d rows, rejected %d rows", rowCount, rowCount-rejects.count, rejects.count)
+	if meta.NumRows > 0 && int64(rowCount) != meta.NumRows {
+		log.Warnf("Read %d rows but the dataset stats count %d rows", rowCount, meta.NumRows)
+	}
+	if rejects.count > 0 {
+		log.Warnf("Rejected rows written to %s", rejects.path)
+	}
+	if rowCount > 0 && float64(rejects.count)/float64(rowCount) > config.ErrThreshold {
+		return fmt.Errorf("rejected %d of %d rows, exceeding the error threshold of %v", rejects.count, rowCount, config.ErrThreshold)
+	}
 
 	return nil
 }
+
+// rowRejects writes the rows rejected during a postgres ingest to a CSV
+// file, along with their line number and the reason for the rejection.
+type rowRejects struct {
+	path   string
+	file   *os.File
+	writer *csv.Writer
+	count  int
+}
+
+func (r *rowRejects) add(lineNumber int, reason string, line string) error {
+	if r.writer == nil {
+		file, err := os.Create(r.path)
+		if err != nil {
+			return errors.Wrap(err, "unable to create reject file")
+		}
+		r.file = file
+		r.writer = csv.NewWriter(file)
+		r.writer.Write([]string{"line", "reason", "row"})
+	}
+	r.count = r.count + 1
+
+	return r.writer.Write([]string{strconv.Itoa(lineNumber), reason, line})
+}
+
+func (r *rowRejects) close() error {
+	if r.writer == nil {
+		return nil
+	}
+	r.writer.Flush()
+	err := r.writer.Error()
+	if err != nil {
+		r.file.Close()
+		return errors.Wrap(err, "unable to write reject file")
+	}
+
+	return r.file.Close()
+}
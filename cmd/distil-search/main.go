@@ -0,0 +1,182 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/unchartedsoftware/plog"
+	"github.com/urfave/cli"
+	elastic "gopkg.in/olivere/elastic.v5"
+
+	"github.com/uncharted-distil/distil-ingest/es"
+	"github.com/uncharted-distil/distil-ingest/metadata"
+)
+
+const (
+	timeout           = time.Second * 60
+	metadataIndexName = "datasets"
+
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+func main() {
+
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	app := cli.NewApp()
+	app.Name = "distil-search"
+	app.Version = "0.1.0"
+	app.Usage = "Search the datasets of the metadata index"
+	app.UsageText = "distil-search --es-endpoint=<url> [options] [text]"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "es-endpoint",
+			Value: "",
+			Usage: "The Elasticsearch endpoint",
+		},
+		cli.StringFlag{
+			Name:  "es-metadata-index",
+			Value: metadataIndexName,
+			Usage: "The Elasticsearch index holding the metadata",
+		},
+		cli.StringFlag{
+			Name:  "variable",
+			Value: "",
+			Usage: "Only match datasets with a variable of that name",
+		},
+		cli.StringFlag{
+			Name:  "type",
+			Value: "",
+			Usage: "Only match datasets with a variable of that type",
+		},
+		cli.StringFlag{
+			Name:  "source",
+			Value: "",
+			Usage: "Only match datasets from that source (seed, contrib or augmented)",
+		},
+		cli.Int64Flag{
+			Name:  "min-rows",
+			Value: -1,
+			Usage: "Only match datasets with at least that many rows",
+		},
+		cli.Int64Flag{
+			Name:  "max-rows",
+			Value: -1,
+			Usage: "Only match datasets with at most that many rows",
+		},
+		cli.IntFlag{
+			Name:  "size",
+			Value: 20,
+			Usage: "The maximum number of datasets to list",
+		},
+		cli.StringFlag{
+			Name:  "format",
+			Value: formatTable,
+			Usage: "The output format (table or json)",
+		},
+	}
+	app.Action = func(c *cli.Context) error {
+		if c.String("es-endpoint") == "" {
+			return cli.NewExitError("missing commandline flag `--es-endpoint`", 1)
+		}
+		format := c.String("format")
+		if format != formatTable && format != formatJSON {
+			return cli.NewExitError(fmt.Sprintf("unrecognized output format '%s'", format), 1)
+		}
+
+		query := &metadata.SearchQuery{
+			Text:         strings.Join(c.Args(), " "),
+			VariableName: c.String("variable"),
+			VariableType: c.String("type"),
+			MinRows:      c.Int64("min-rows"),
+			MaxRows:      c.Int64("max-rows"),
+		}
+		if c.String("source") != "" {
+			source, err := metadata.ParseDatasetSource(c.String("source"))
+			if err != nil {
+				return cli.NewExitError(errors.Cause(err), 1)
+			}
+			query.Source = source
+		}
+
+		// create elasticsearch client
+		elasticClient, err := elastic.NewClient(
+			elastic.SetURL(c.String("es-endpoint")),
+			elastic.SetHttpClient(&http.Client{Timeout: timeout}),
+			elastic.SetMaxRetries(10),
+			elastic.SetSniff(false),
+			elastic.SetGzip(true))
+		if err != nil {
+			log.Errorf("%v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+
+		cluster, err := es.DetectCluster(elasticClient, c.String("es-endpoint"))
+		if err != nil {
+			log.Errorf("%v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+
+		results, err := metadata.SearchMetadata(cluster, c.String("es-metadata-index"), query, c.Int("size"))
+		if err != nil {
+			log.Errorf("%v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+
+		if format == formatJSON {
+			err = printJSON(results)
+		} else {
+			err = printTable(results)
+		}
+		if err != nil {
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+
+		return nil
+	}
+	// run app
+	app.Run(os.Args)
+}
+
+func printTable(results []*metadata.SearchResult) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSOURCE\tROWS\tVARIABLES\tSCORE")
+	for _, r := range results {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%d\t%.3f\n", r.ID, r.Name, r.Source, r.NumRows, len(r.Variables), r.Score)
+	}
+
+	return w.Flush()
+}
+
+func printJSON(results []*metadata.SearchResult) error {
+	output, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return errors.Wrap(err, "failed to marshal search results")
+	}
+	fmt.Println(string(output))
+
+	return nil
+}
@@ -0,0 +1,79 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+
+	"github.com/pkg/errors"
+	log "github.com/unchartedsoftware/plog"
+	"github.com/urfave/cli"
+
+	"github.com/uncharted-distil/distil-ingest/metadata"
+)
+
+func main() {
+
+	runtime.GOMAXPROCS(runtime.NumCPU())
+
+	app := cli.NewApp()
+	app.Name = "distil-validate"
+	app.Version = "0.1.0"
+	app.Usage = "Validate a D3M dataset schema along with the files it references"
+	app.UsageText = "distil-validate --schema=<filepath>"
+	app.Flags = []cli.Flag{
+		cli.StringFlag{
+			Name:  "schema",
+			Value: "",
+			Usage: "The dataset schema file path",
+		},
+		cli.BoolFlag{
+			Name:  "strict",
+			Usage: "Fail the validation on warnings as well as errors",
+		},
+		cli.BoolFlag{
+			Name:  "collect-unknown-resources",
+			Usage: "Load resources of an unknown type as generic collections instead of failing",
+		},
+	}
+	app.Action = func(c *cli.Context) error {
+		if c.String("schema") == "" {
+			return cli.NewExitError("missing commandline flag `--schema`", 1)
+		}
+		schemaPath := c.String("schema")
+
+		problems, err := metadata.ValidateSchema(schemaPath, metadata.CollectUnknownResources(c.Bool("collect-unknown-resources")))
+		if err != nil {
+			log.Errorf("%v", err)
+			return cli.NewExitError(errors.Cause(err), 2)
+		}
+
+		for _, p := range problems {
+			fmt.Println(p.String())
+		}
+
+		if metadata.HasErrors(problems) || (c.Bool("strict") && len(problems) > 0) {
+			return cli.NewExitError(fmt.Sprintf("schema %s is invalid, %d problems found", schemaPath, len(problems)), 3)
+		}
+
+		log.Infof("Schema %s is valid, %d problems found", schemaPath, len(problems))
+		return nil
+	}
+	// run app
+	app.Run(os.Args)
+}
@@ -20,14 +20,16 @@ var config *Conf
 // Conf represents all the ingest runtime flags passed to the binary.
 type Conf struct {
 	// elasticsearch config
-	ESEndpoint      string
-	ESIndex         string
-	ESDatasetPrefix string
-	DocType         string
-	ClearExisting   bool
-	BulkByteSize    int64
-	ScanBufferSize  int
-	DatasetFolder   string
+	ESEndpoint         string
+	ESIndex            string
+	ESDatasetPrefix    string
+	ESRetention        int
+	ESMetadataTemplate string
+	DocType            string
+	ClearExisting      bool
+	BulkByteSize       int64
+	ScanBufferSize     int
+	DatasetFolder      string
 
 	// d3m dataset directory path
 	TypeSource         string
@@ -47,15 +49,23 @@ type Conf struct {
 	ProbabilityThreshold float64
 
 	// postgres config
-	Database    string
-	DBTable     string
-	DBUser      string
-	DBPassword  string
-	DBBatchSize int
-	DBHost      string
-	DBPort      int
+	Database       string
+	DBTable        string
+	DBUser         string
+	DBPassword     string
+	DBBatchSize    int
+	DBHost         string
+	DBPort         int
+	DBLoader       string
+	DBRejectPath   string
+	DBTypedStorage bool
+	DBIndexes      string
+	DBSearch       string
+	DBGeography    bool
 
 	// control flags
 	IncludeRaw   bool
 	MetadataOnly bool
+	Append       bool
+	AddVariables bool
 }
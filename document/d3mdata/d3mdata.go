@@ -17,6 +17,7 @@ package d3mdata
 
 import (
 	"fmt"
+	"strings"
 
 	"github.com/jeffail/gabs"
 	"github.com/pkg/errors"
@@ -24,13 +25,17 @@ import (
 	"github.com/unchartedsoftware/deluge/document"
 
 	"github.com/uncharted-distil/distil-compute/model"
+	log "github.com/unchartedsoftware/plog"
 )
 
 // D3MData is a row from a CSV file
 type D3MData struct {
 	document.CSV
-	meta  *model.Metadata
-	idCol int
+	meta        *model.Metadata
+	idCol       int
+	dateLayouts map[string]string
+	geoPoints   []*geoPoint
+	stats       *ParseStats
 }
 
 func getIDColumn(meta *model.Metadata) (int, error) {
@@ -43,26 +48,52 @@ func getIDColumn(meta *model.Metadata) (int, error) {
 	return -1, errors.Errorf("no id column found")
 }
 
-// NewD3MData instantiates and returns a new document using metadata.
-func NewD3MData(meta *model.Metadata) (deluge.Constructor, error) {
+// NewD3MData instantiates and returns a new document using metadata. Values
+// which cannot be parsed are counted in the stats, which can be nil.
+func NewD3MData(meta *model.Metadata, stats *ParseStats) (deluge.Constructor, error) {
 	// get id column and cache for later
 	idCol, err := getIDColumn(meta)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to parse schema file")
 	}
+	// unrecognized types are stored as keywords rather than failing the ingest.
+	for _, v := range meta.DataResources[0].Variables {
+		if _, ok := fieldType(v.Type); !ok {
+			log.Warnf("unknown data type %s for variable %s, storing it as a keyword", v.Type, v.Name)
+		}
+	}
+
+	dateLayouts := schemaDateLayouts(meta)
+	points := geoPoints(meta)
+
 	return func() (deluge.Document, error) {
 		return &D3MData{
-			meta:  meta,
-			idCol: idCol,
+			meta:        meta,
+			idCol:       idCol,
+			dateLayouts: dateLayouts,
+			geoPoints:   points,
+			stats:       stats,
 		}, nil
 	}, nil
 }
 
 // GetID returns the document id.
 func (d *D3MData) GetID() (string, error) {
+	if d.idCol >= len(d.Cols) {
+		return "", errors.Errorf("row of %d columns has no id column", len(d.Cols))
+	}
 	return d.Cols[d.idCol], nil
 }
 
+// column returns the value of a column, which is empty when the row is too
+// short to hold it.
+func (d *D3MData) column(index int) string {
+	if index < 0 || index >= len(d.Cols) {
+		return ""
+	}
+	return d.Cols[index]
+}
+
 // GetType returns the document type.
 func (d *D3MData) GetType() (string, error) {
 	return "datum", nil
@@ -79,36 +110,22 @@ func (d *D3MData) GetMapping() (string, error) {
 			continue
 		}
 
-		var varType string
-
-		switch v.Type {
-		case "integer", "index":
-			varType = "long"
-			break
-		case "float", "latitude", "longitude":
-			varType = "double"
-			break
-		case "text":
-			varType = "text"
-			break
-		case "categorical", "ordinal", "address", "city", "state", "country", "email", "phone", "postal_code", "uri":
-			varType = "keyword"
-			break
-		case "dateTime":
-			varType = "date"
-			break
-		case "boolean":
-			varType = "boolean"
-			break
-		default:
-			return "", fmt.Errorf("Unknown data type %s", varType)
-		}
+		varType, _ := fieldType(v.Type)
 
 		varNameKey := fmt.Sprintf("datum.properties.%s.properties.value.type", v.Name)
 		varTypeKey := fmt.Sprintf("datum.properties.%s.properties.schemaType.type", v.Name)
 
 		mappings.SetP(varType, varNameKey)
 		mappings.SetP("keyword", varTypeKey)
+		if varType == "date" {
+			mappings.SetP(dateFormat, fmt.Sprintf("datum.properties.%s.properties.value.format", v.Name))
+		}
+	}
+
+	// geo pairs are combined into a single point
+	for _, p := range d.geoPoints {
+		mappings.SetP("geo_point", fmt.Sprintf("datum.properties.%s.properties.value.type", p.name))
+		mappings.SetP("keyword", fmt.Sprintf("datum.properties.%s.properties.schemaType.type", p.name))
 	}
 
 	return mappings.String(), nil
@@ -125,21 +142,30 @@ func (d *D3MData) GetSource() (interface{}, error) {
 
 		var varValue interface{}
 
-		switch v.Type {
-		case "integer", "index", "dateTime":
+		varType, _ := fieldType(v.Type)
+		switch varType {
+		case "long":
 			varValue, _ = d.Int64(index)
-			break
-		case "float", "latitude", "longitude":
-			varValue, _ = d.Float64(index)
-			break
-		case "text", "categorical", "ordinal", "address", "city", "state", "country", "email", "phone", "postal_code", "uri":
-			varValue, _ = d.String(index)
-			break
+		case "date":
+			// unparseable dates are left empty rather than set to the epoch.
+			date, ok := parseDate(d.column(index), d.dateLayouts[v.Name])
+			if ok {
+				varValue = date
+			} else if strings.TrimSpace(d.column(index)) != "" {
+				d.stats.addFailure(v.Name)
+			}
+		case "double":
+			if isVector(v.Type) {
+				varValue = parseVector(d.column(index))
+			} else {
+				varValue, _ = d.Float64(index)
+			}
 		case "boolean":
 			varValue, _ = d.Bool(index)
-			break
+		case "geo_point":
+			varValue = parseGeoPoint(d.column(index))
 		default:
-			return "", fmt.Errorf("unknown data type %s", v.Type)
+			varValue, _ = d.String(index)
 		}
 
 		// set entry
@@ -149,5 +175,18 @@ func (d *D3MData) GetSource() (interface{}, error) {
 		}
 	}
 
+	for _, p := range d.geoPoints {
+		lat := d.column(p.latCol)
+		lon := d.column(p.lonCol)
+		point := parseGeoPair(lat, lon)
+		if point == nil && (strings.TrimSpace(lat) != "" || strings.TrimSpace(lon) != "") {
+			d.stats.addFailure(p.name)
+		}
+		source[p.name] = map[string]interface{}{
+			"value":      point,
+			"schemaType": "geocoordinate",
+		}
+	}
+
 	return source, nil
 }
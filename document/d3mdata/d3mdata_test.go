@@ -21,6 +21,7 @@ import (
 	"github.com/jeffail/gabs"
 	"github.com/stretchr/testify/assert"
 
+	"github.com/uncharted-distil/distil-compute/model"
 	"github.com/uncharted-distil/distil-ingest/metadata"
 )
 
@@ -30,7 +31,7 @@ func TestGetSource(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Create a document using the test json schema
-	docCreate, err := NewD3MData(meta)
+	docCreate, err := NewD3MData(meta, nil)
 	assert.NoError(t, err)
 
 	doc, err := docCreate()
@@ -75,7 +76,7 @@ func TestGetMapping(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Create a document using the test json schema
-	docCreate, err := NewD3MData(meta)
+	docCreate, err := NewD3MData(meta, nil)
 	assert.NoError(t, err)
 
 	doc, err := docCreate()
@@ -108,7 +109,7 @@ func TestID(t *testing.T) {
 	assert.NoError(t, err)
 
 	// Create a document using the test json schema
-	docCreate, err := NewD3MData(meta)
+	docCreate, err := NewD3MData(meta, nil)
 	assert.NoError(t, err)
 
 	doc, err := docCreate()
@@ -126,3 +127,162 @@ func TestID(t *testing.T) {
 	// Verify the id
 	assert.Equal(t, "0", id)
 }
+
+func TestGetSourceExtendedTypes(t *testing.T) {
+
+	variables := []*model.Variable{
+		{Name: "d3mIndex", Type: "index", SelectedRole: "index"},
+		{Name: "Vector", Type: "realVector", SelectedRole: "attribute"},
+		{Name: "Location", Type: "geocoordinate", SelectedRole: "attribute"},
+		{Name: "Image", Type: "image", SelectedRole: "attribute"},
+		{Name: "Mystery", Type: "somethingNew", SelectedRole: "attribute"},
+	}
+	meta := &model.Metadata{
+		DataResources: []*model.DataResource{{Variables: variables}},
+	}
+
+	docCreate, err := NewD3MData(meta, nil)
+	assert.NoError(t, err)
+
+	doc, err := docCreate()
+	assert.NoError(t, err)
+
+	doc.SetData("0,\"1.5,2,3\",\"45.5, -73.6\",img_0.png,abc")
+
+	output, err := doc.GetSource()
+	assert.NoError(t, err)
+	source := output.(map[string]interface{})
+
+	assert.Equal(t, []float64{1.5, 2, 3}, source["Vector"].(map[string]interface{})["value"])
+	assert.Equal(t, map[string]float64{"lat": 45.5, "lon": -73.6}, source["Location"].(map[string]interface{})["value"])
+	assert.Equal(t, "img_0.png", source["Image"].(map[string]interface{})["value"])
+	assert.Equal(t, "abc", source["Mystery"].(map[string]interface{})["value"])
+
+	strMapping, err := doc.GetMapping()
+	assert.NoError(t, err)
+
+	mapping, err := gabs.ParseJSON([]byte(strMapping))
+	assert.NoError(t, err)
+	assert.Equal(t, "double", mapping.Path("datum.properties.Vector.properties.value.type").Data().(string))
+	assert.Equal(t, "geo_point", mapping.Path("datum.properties.Location.properties.value.type").Data().(string))
+	assert.Equal(t, "keyword", mapping.Path("datum.properties.Image.properties.value.type").Data().(string))
+	assert.Equal(t, "keyword", mapping.Path("datum.properties.Mystery.properties.value.type").Data().(string))
+
+	// short rows leave the missing values empty.
+	doc.SetData("0,\"1.5,2,3\"")
+
+	output, err = doc.GetSource()
+	assert.NoError(t, err)
+	source = output.(map[string]interface{})
+
+	assert.Equal(t, []float64{1.5, 2, 3}, source["Vector"].(map[string]interface{})["value"])
+	assert.Nil(t, source["Location"].(map[string]interface{})["value"])
+	assert.Equal(t, "", source["Image"].(map[string]interface{})["value"])
+}
+
+func TestGetSourceDates(t *testing.T) {
+
+	variables := []*model.Variable{
+		{Name: "d3mIndex", Type: "index", SelectedRole: "index"},
+		{Name: "ISO", Type: "dateTime", SelectedRole: "attribute"},
+		{Name: "Day", Type: "dateTime", SelectedRole: "attribute"},
+		{Name: "DayFirst", Type: "dateTime", SelectedRole: "attribute"},
+		{Name: "Ambiguous", Type: "dateTime", SelectedRole: "attribute"},
+		{Name: "Invalid", Type: "dateTime", SelectedRole: "attribute"},
+	}
+	meta := &model.Metadata{
+		DataResources: []*model.DataResource{{Variables: variables}},
+	}
+
+	stats := NewParseStats()
+	docCreate, err := NewD3MData(meta, stats)
+	assert.NoError(t, err)
+
+	doc, err := docCreate()
+	assert.NoError(t, err)
+
+	doc.SetData("0,2019-03-01T12:30:00Z,03/25/2019,25/03/2019,03/01/2019,not a date")
+
+	output, err := doc.GetSource()
+	assert.NoError(t, err)
+	source := output.(map[string]interface{})
+
+	assert.Equal(t, int64(1551443400000), source["ISO"].(map[string]interface{})["value"])
+	assert.Equal(t, int64(1553472000000), source["Day"].(map[string]interface{})["value"])
+	assert.Equal(t, int64(1553472000000), source["DayFirst"].(map[string]interface{})["value"])
+	assert.Nil(t, source["Ambiguous"].(map[string]interface{})["value"])
+	assert.Nil(t, source["Invalid"].(map[string]interface{})["value"])
+	assert.Equal(t, map[string]int{"Ambiguous": 1, "Invalid": 1}, stats.Failures())
+
+	// short rows leave the missing dates empty without counting a failure.
+	doc.SetData("0,2019-03-01T12:30:00Z")
+
+	output, err = doc.GetSource()
+	assert.NoError(t, err)
+	source = output.(map[string]interface{})
+
+	assert.Nil(t, source["Day"].(map[string]interface{})["value"])
+	assert.Equal(t, map[string]int{"Ambiguous": 1, "Invalid": 1}, stats.Failures())
+
+	strMapping, err := doc.GetMapping()
+	assert.NoError(t, err)
+
+	mapping, err := gabs.ParseJSON([]byte(strMapping))
+	assert.NoError(t, err)
+	assert.Equal(t, "epoch_millis", mapping.Path("datum.properties.ISO.properties.value.format").Data().(string))
+}
+
+func TestGetSourceGeoPair(t *testing.T) {
+
+	lat := &model.Variable{Name: "_lat_city", SelectedRole: "attribute", DistilRole: model.VarRoleMetadata}
+	lon := &model.Variable{Name: "_lon_city", SelectedRole: "attribute", DistilRole: model.VarRoleMetadata}
+	metadata.MarkGeoPair("city", lat, lon)
+	assert.Nil(t, lat.RefersTo)
+	assert.Equal(t, model.VarRoleMetadata, lat.DistilRole)
+	assert.Equal(t, model.VarRoleMetadata, lon.DistilRole)
+
+	// coordinates read from the dataset are not paired.
+	variables := []*model.Variable{
+		{Name: "d3mIndex", Type: "index", SelectedRole: "index"},
+		{Name: "city", Type: "city", SelectedRole: "attribute"},
+		lat,
+		lon,
+	}
+	assert.Equal(t, 1, len(metadata.GeoPairs(append(variables,
+		&model.Variable{Name: "lat", Type: model.LatitudeType, OriginalVariable: "lat"},
+		&model.Variable{Name: "lon", Type: model.LongitudeType, OriginalVariable: "lon"}))))
+	meta := &model.Metadata{
+		DataResources: []*model.DataResource{{Variables: variables}},
+	}
+
+	docCreate, err := NewD3MData(meta, nil)
+	assert.NoError(t, err)
+
+	doc, err := docCreate()
+	assert.NoError(t, err)
+
+	doc.SetData("0,Ottawa,45.42,-75.69")
+
+	output, err := doc.GetSource()
+	assert.NoError(t, err)
+	source := output.(map[string]interface{})
+
+	assert.Equal(t, 45.42, source["_lat_city"].(map[string]interface{})["value"])
+	assert.Equal(t, map[string]float64{"lat": 45.42, "lon": -75.69}, source["_geo_city"].(map[string]interface{})["value"])
+
+	strMapping, err := doc.GetMapping()
+	assert.NoError(t, err)
+
+	mapping, err := gabs.ParseJSON([]byte(strMapping))
+	assert.NoError(t, err)
+	assert.Equal(t, "geo_point", mapping.Path("datum.properties._geo_city.properties.value.type").Data().(string))
+
+	// a short row leaves the point empty.
+	doc.SetData("0,Ottawa,45.42")
+
+	output, err = doc.GetSource()
+	assert.NoError(t, err)
+	source = output.(map[string]interface{})
+
+	assert.Nil(t, source["_geo_city"].(map[string]interface{})["value"])
+}
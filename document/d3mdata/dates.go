@@ -0,0 +1,216 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package d3mdata
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+const (
+	// dateFormat is the format of the date fields, which hold the number of
+	// milliseconds since the epoch.
+	dateFormat = "epoch_millis"
+
+	// layoutKey is the distil column property of the schema holding the
+	// layout of a dateTime variable, expressed as a go time layout.
+	layoutKey = "distilDateLayout"
+)
+
+var (
+	// dateLayouts are the layouts tried, in order, when a dateTime variable
+	// has no layout in the schema.
+	dateLayouts = []string{
+		time.RFC3339Nano,
+		time.RFC3339,
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05Z07:00",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+		"2006/01/02 15:04:05",
+		"2006/01/02",
+		"01/02/2006 15:04:05",
+		"01/02/2006 15:04",
+		"01/02/2006",
+		"1/2/2006 15:04:05",
+		"1/2/2006 15:04",
+		"1/2/2006",
+		"02/01/2006 15:04:05",
+		"02/01/2006 15:04",
+		"02/01/2006",
+		"2/1/2006 15:04:05",
+		"2/1/2006 15:04",
+		"2/1/2006",
+		"02-Jan-2006",
+		"2 Jan 2006",
+		"Jan 2, 2006",
+		"January 2, 2006",
+		"2006-01",
+		"2006",
+		time.RFC1123Z,
+		time.RFC1123,
+		time.RFC850,
+		time.RFC822Z,
+		time.RFC822,
+		time.UnixDate,
+		time.ANSIC,
+	}
+
+	// dayFirstLayouts maps the month first layouts to their day first
+	// counterparts, which read the same values differently.
+	dayFirstLayouts = map[string]string{
+		"01/02/2006 15:04:05": "02/01/2006 15:04:05",
+		"01/02/2006 15:04":    "02/01/2006 15:04",
+		"01/02/2006":          "02/01/2006",
+		"1/2/2006 15:04:05":   "2/1/2006 15:04:05",
+		"1/2/2006 15:04":      "2/1/2006 15:04",
+		"1/2/2006":            "2/1/2006",
+	}
+)
+
+// ParseStats counts the values of every variable which could not be parsed
+// during an ingest.
+type ParseStats struct {
+	failures map[string]int
+	lock     sync.Mutex
+}
+
+// NewParseStats creates empty parse stats.
+func NewParseStats() *ParseStats {
+	return &ParseStats{
+		failures: make(map[string]int),
+	}
+}
+
+func (s *ParseStats) addFailure(varName string) {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.failures[varName] = s.failures[varName] + 1
+}
+
+// Failures returns the number of unparseable values of every variable which
+// had at least one.
+func (s *ParseStats) Failures() map[string]int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	failures := make(map[string]int)
+	for name, count := range s.failures {
+		failures[name] = count
+	}
+
+	return failures
+}
+
+// FailedVariables returns the sorted names of the variables with unparseable
+// values.
+func (s *ParseStats) FailedVariables() []string {
+	failures := s.Failures()
+	names := make([]string, 0, len(failures))
+	for name := range failures {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// schemaDateLayouts reads the layouts of the dateTime variables set in the
+// schema.
+func schemaDateLayouts(meta *model.Metadata) map[string]string {
+	layouts := make(map[string]string)
+	if meta.Schema == nil {
+		return layouts
+	}
+
+	resources, err := meta.Schema.Path("dataResources").Children()
+	if err != nil {
+		return layouts
+	}
+	for _, res := range resources {
+		columns, err := res.Path("columns").Children()
+		if err != nil {
+			continue
+		}
+		for _, c := range columns {
+			name, ok := c.Path("colName").Data().(string)
+			if !ok {
+				continue
+			}
+			layout, ok := c.Path(layoutKey).Data().(string)
+			if ok && layout != "" {
+				layouts[name] = layout
+			}
+		}
+	}
+
+	return layouts
+}
+
+// parseDate parses a date into milliseconds since the epoch. The layout is
+// used if set, otherwise the common layouts are tried before falling back
+// to the value being a timestamp. Dates which are valid with either the day
+// or the month first, but differ, are not parsed.
+func parseDate(value string, layout string) (int64, bool) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return 0, false
+	}
+
+	if layout != "" {
+		t, err := time.Parse(layout, value)
+		if err != nil {
+			return 0, false
+		}
+		return toEpochMillis(t), true
+	}
+
+	for _, l := range dateLayouts {
+		t, err := time.Parse(l, value)
+		if err != nil {
+			continue
+		}
+		if dayFirst, ok := dayFirstLayouts[l]; ok {
+			swapped, err := time.Parse(dayFirst, value)
+			if err == nil && !swapped.Equal(t) {
+				return 0, false
+			}
+		}
+		return toEpochMillis(t), true
+	}
+
+	// integer values are assumed to already be timestamps.
+	timestamp, err := strconv.ParseInt(value, 10, 64)
+	if err == nil {
+		return timestamp, true
+	}
+
+	return 0, false
+}
+
+func toEpochMillis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
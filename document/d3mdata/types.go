@@ -0,0 +1,164 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package d3mdata
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/uncharted-distil/distil-compute/model"
+
+	"github.com/uncharted-distil/distil-ingest/metadata"
+)
+
+var (
+	// fieldTypes maps the variable types to the type of their ES field.
+	fieldTypes = map[string]string{
+		"index":         "long",
+		"integer":       "long",
+		"float":         "double",
+		"real":          "double",
+		"latitude":      "double",
+		"longitude":     "double",
+		"realVector":    "double",
+		"text":          "text",
+		"categorical":   "keyword",
+		"ordinal":       "keyword",
+		"address":       "keyword",
+		"city":          "keyword",
+		"state":         "keyword",
+		"country":       "keyword",
+		"email":         "keyword",
+		"phone":         "keyword",
+		"postal_code":   "keyword",
+		"uri":           "keyword",
+		"string":        "keyword",
+		"unknown":       "keyword",
+		"image":         "keyword",
+		"timeseries":    "keyword",
+		"audio":         "keyword",
+		"video":         "keyword",
+		"dateTime":      "date",
+		"boolean":       "boolean",
+		"geocoordinate": "geo_point",
+	}
+)
+
+// fieldType returns the ES field type of a variable type. Unrecognized types
+// are stored as keywords, in which case false is returned.
+func fieldType(typ string) (string, bool) {
+	if esType, ok := fieldTypes[typ]; ok {
+		return esType, true
+	}
+	if isVector(typ) {
+		return "double", true
+	}
+	return "keyword", false
+}
+
+func isVector(typ string) bool {
+	return strings.HasSuffix(typ, "Vector")
+}
+
+// splitValues splits a list of numbers, optionally enclosed in brackets or
+// braces, on commas or spaces.
+func splitValues(value string) []string {
+	value = strings.Trim(strings.TrimSpace(value), "[]{}()")
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
+
+// parseVector parses a vector value into an array of doubles. Nil is returned
+// if any element is not a number.
+func parseVector(value string) []float64 {
+	fields := splitValues(value)
+	vector := make([]float64, len(fields))
+	for i, f := range fields {
+		parsed, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil
+		}
+		vector[i] = parsed
+	}
+
+	return vector
+}
+
+// parseGeoPoint parses a latitude, longitude pair into an ES geo point. Nil
+// is returned if the value is not a valid pair.
+func parseGeoPoint(value string) map[string]float64 {
+	coordinates := parseVector(value)
+	if len(coordinates) != 2 {
+		return nil
+	}
+
+	return newGeoPoint(coordinates[0], coordinates[1])
+}
+
+// parseGeoPair parses separate latitude and longitude values into an ES geo
+// point. Nil is returned if either value is invalid.
+func parseGeoPair(latValue string, lonValue string) map[string]float64 {
+	lat, err := strconv.ParseFloat(strings.TrimSpace(latValue), 64)
+	if err != nil {
+		return nil
+	}
+	lon, err := strconv.ParseFloat(strings.TrimSpace(lonValue), 64)
+	if err != nil {
+		return nil
+	}
+
+	return newGeoPoint(lat, lon)
+}
+
+func newGeoPoint(lat float64, lon float64) map[string]float64 {
+	if lat < -90 || lat > 90 || lon < -180 || lon > 180 {
+		return nil
+	}
+
+	return map[string]float64{
+		"lat": lat,
+		"lon": lon,
+	}
+}
+
+// geoPoint is a field combining the latitude and longitude columns of a geo
+// pair.
+type geoPoint struct {
+	name   string
+	latCol int
+	lonCol int
+}
+
+// geoPoints finds the columns of the geo pairs of the metadata.
+func geoPoints(meta *model.Metadata) []*geoPoint {
+	variables := meta.DataResources[0].Variables
+	columns := make(map[*model.Variable]int)
+	for index, v := range variables {
+		columns[v] = index
+	}
+
+	points := make([]*geoPoint, 0)
+	for _, pair := range metadata.GeoPairs(variables) {
+		points = append(points, &geoPoint{
+			name:   pair.Name,
+			latCol: columns[pair.Latitude],
+			lonCol: columns[pair.Longitude],
+		})
+	}
+
+	return points
+}
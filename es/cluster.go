@@ -0,0 +1,333 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	log "github.com/unchartedsoftware/plog"
+	elastic "gopkg.in/olivere/elastic.v5"
+)
+
+const (
+	// typelessMajorVersion is the first major version without mapping types.
+	typelessMajorVersion = 7
+	// allFieldMajorVersion is the first major version without the _all field.
+	allFieldMajorVersion = 6
+
+	typelessDocType = "_doc"
+)
+
+// Cluster hides the differences in index and document handling between the
+// versions of Elasticsearch.
+type Cluster interface {
+	// Client returns the underlying client, for the requests which are the
+	// same on every version.
+	Client() *elastic.Client
+	// Version returns the version of the cluster.
+	Version() string
+	// SupportsAllField indicates whether or not fields can be included in
+	// the _all field.
+	SupportsAllField() bool
+	// SupportsMappingTypes indicates whether or not documents can be written
+	// with a mapping type, as the deluge ingest of the rows does.
+	SupportsMappingTypes() bool
+	// Mappings wraps the properties of a document type into index mappings.
+	Mappings(docType string, properties map[string]interface{}) map[string]interface{}
+	// CreateIndex creates an index with the settings and mappings.
+	CreateIndex(index string, settings map[string]interface{}, mappings map[string]interface{}) error
+	// IndexDocument stores a document of the type in the index.
+	IndexDocument(index string, docType string, id string, source string) error
+	// BulkIndex stores the documents of the type in the index in a single
+	// request, returning an error for each document which was not stored.
+	BulkIndex(index string, docType string, docs []*BulkDocument) ([]error, error)
+	// Search returns the best scoring documents of the index matching the
+	// query.
+	Search(index string, query elastic.Query, size int) (*elastic.SearchHits, error)
+}
+
+// BulkDocument is a document to store with a bulk request.
+type BulkDocument struct {
+	ID     string
+	Source interface{}
+}
+
+// DetectCluster selects the cluster implementation matching the version of
+// the server at the endpoint.
+func DetectCluster(client *elastic.Client, endpoint string) (Cluster, error) {
+	version, err := client.ElasticsearchVersion(endpoint)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to read the version of %s", endpoint)
+	}
+	log.Infof("Using Elasticsearch version %s at %s", version, endpoint)
+
+	return NewCluster(client, version)
+}
+
+// NewCluster creates the cluster implementation for the version.
+func NewCluster(client *elastic.Client, version string) (Cluster, error) {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse version %s", version)
+	}
+
+	if major >= typelessMajorVersion {
+		return &typelessCluster{
+			client:  client,
+			version: version,
+		}, nil
+	}
+
+	return &legacyCluster{
+		client:   client,
+		version:  version,
+		allField: major < allFieldMajorVersion,
+	}, nil
+}
+
+// legacyCluster uses mapping types, which were removed in version 7.
+type legacyCluster struct {
+	client   *elastic.Client
+	version  string
+	allField bool
+}
+
+func (c *legacyCluster) Client() *elastic.Client {
+	return c.client
+}
+
+func (c *legacyCluster) Version() string {
+	return c.version
+}
+
+func (c *legacyCluster) SupportsAllField() bool {
+	return c.allField
+}
+
+func (c *legacyCluster) SupportsMappingTypes() bool {
+	return true
+}
+
+func (c *legacyCluster) Mappings(docType string, properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		docType: map[string]interface{}{
+			"properties": properties,
+		},
+	}
+}
+
+func (c *legacyCluster) CreateIndex(index string, settings map[string]interface{}, mappings map[string]interface{}) error {
+	return createIndex(c.client, index, settings, mappings)
+}
+
+func (c *legacyCluster) IndexDocument(index string, docType string, id string, source string) error {
+	_, err := c.client.Index().
+		Index(index).
+		Type(docType).
+		Id(id).
+		BodyString(source).
+		Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to add document to index `%s`", index)
+	}
+
+	return nil
+}
+
+func (c *legacyCluster) BulkIndex(index string, docType string, docs []*BulkDocument) ([]error, error) {
+	bulk := c.client.Bulk()
+	for _, doc := range docs {
+		bulk.Add(elastic.NewBulkIndexRequest().Index(index).Type(docType).Id(doc.ID).Doc(doc.Source))
+	}
+
+	res, err := bulk.Do(context.Background())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to add documents to index `%s`", index)
+	}
+
+	return bulkErrors(res), nil
+}
+
+func (c *legacyCluster) Search(index string, query elastic.Query, size int) (*elastic.SearchHits, error) {
+	res, err := c.client.Search(index).
+		Query(query).
+		Size(size).
+		Do(context.Background())
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to search index `%s`", index)
+	}
+
+	return res.Hits, nil
+}
+
+// typelessCluster stores a single unnamed document type per index.
+type typelessCluster struct {
+	client  *elastic.Client
+	version string
+}
+
+func (c *typelessCluster) Client() *elastic.Client {
+	return c.client
+}
+
+func (c *typelessCluster) Version() string {
+	return c.version
+}
+
+func (c *typelessCluster) SupportsAllField() bool {
+	return false
+}
+
+func (c *typelessCluster) SupportsMappingTypes() bool {
+	return false
+}
+
+func (c *typelessCluster) Mappings(docType string, properties map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"properties": properties,
+	}
+}
+
+func (c *typelessCluster) CreateIndex(index string, settings map[string]interface{}, mappings map[string]interface{}) error {
+	return createIndex(c.client, index, settings, mappings)
+}
+
+func (c *typelessCluster) IndexDocument(index string, docType string, id string, source string) error {
+	// the client builds typed paths so the request is built directly.
+	path := fmt.Sprintf("/%s/%s/%s", url.PathEscape(index), typelessDocType, url.PathEscape(id))
+	_, err := c.client.PerformRequest(context.Background(), "PUT", path, nil, source)
+	if err != nil {
+		return errors.Wrapf(err, "failed to add document to index `%s`", index)
+	}
+
+	return nil
+}
+
+func (c *typelessCluster) BulkIndex(index string, docType string, docs []*BulkDocument) ([]error, error) {
+	body, err := typelessBulkBody(index, docs)
+	if err != nil {
+		return nil, err
+	}
+
+	// the client adds the type to the bulk actions so the request is built
+	// directly.
+	res, err := c.client.PerformRequestWithContentType(context.Background(), "POST", "/_bulk", nil, body, "application/x-ndjson")
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to add documents to index `%s`", index)
+	}
+
+	result := &elastic.BulkResponse{}
+	err = json.Unmarshal(res.Body, result)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse bulk response of index `%s`", index)
+	}
+
+	return bulkErrors(result), nil
+}
+
+func (c *typelessCluster) Search(index string, query elastic.Query, size int) (*elastic.SearchHits, error) {
+	source, err := query.Source()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to build search query")
+	}
+	body := map[string]interface{}{
+		"query": source,
+		"size":  size,
+	}
+
+	// the client cannot read the total hits object so the total is
+	// requested as a number.
+	params := url.Values{}
+	params.Set("rest_total_hits_as_int", "true")
+	path := fmt.Sprintf("/%s/_search", url.PathEscape(index))
+	res, err := c.client.PerformRequest(context.Background(), "POST", path, params, body)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to search index `%s`", index)
+	}
+
+	result := &elastic.SearchResult{}
+	err = json.Unmarshal(res.Body, result)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse search response of index `%s`", index)
+	}
+
+	return result.Hits, nil
+}
+
+// typelessBulkBody writes the index action and the source of each document
+// on separate lines, as expected by the bulk endpoint.
+func typelessBulkBody(index string, docs []*BulkDocument) (string, error) {
+	var body strings.Builder
+	for _, doc := range docs {
+		action, err := json.Marshal(map[string]interface{}{
+			"index": map[string]interface{}{
+				"_index": index,
+				"_id":    doc.ID,
+			},
+		})
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to write bulk action of document %s", doc.ID)
+		}
+		source, err := json.Marshal(doc.Source)
+		if err != nil {
+			return "", errors.Wrapf(err, "failed to write source of document %s", doc.ID)
+		}
+		body.Write(action)
+		body.WriteString("\n")
+		body.Write(source)
+		body.WriteString("\n")
+	}
+
+	return body.String(), nil
+}
+
+// bulkErrors returns an error for each document of the bulk response which
+// was not stored.
+func bulkErrors(res *elastic.BulkResponse) []error {
+	var errs []error
+	for _, item := range res.Failed() {
+		reason := "unknown error"
+		if item.Error != nil {
+			reason = fmt.Sprintf("%s: %s", item.Error.Type, item.Error.Reason)
+		}
+		errs = append(errs, fmt.Errorf("failed to store document %s: %s", item.Id, reason))
+	}
+
+	return errs
+}
+
+func createIndex(client *elastic.Client, index string, settings map[string]interface{}, mappings map[string]interface{}) error {
+	body := map[string]interface{}{
+		"settings": settings,
+		"mappings": mappings,
+	}
+
+	created, err := client.CreateIndex(index).BodyJson(body).Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to create index %s", index)
+	}
+	if !created.Acknowledged {
+		return fmt.Errorf("Failed to create new index %s", index)
+	}
+
+	return nil
+}
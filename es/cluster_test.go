@@ -0,0 +1,55 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package es
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewCluster(t *testing.T) {
+	cluster, err := NewCluster(nil, "5.6.16")
+	assert.NoError(t, err)
+	assert.True(t, cluster.SupportsAllField())
+	assert.True(t, cluster.SupportsMappingTypes())
+
+	cluster, err = NewCluster(nil, "6.8.0")
+	assert.NoError(t, err)
+	assert.False(t, cluster.SupportsAllField())
+	assert.True(t, cluster.SupportsMappingTypes())
+
+	cluster, err = NewCluster(nil, "7.4.2")
+	assert.NoError(t, err)
+	assert.False(t, cluster.SupportsAllField())
+	assert.False(t, cluster.SupportsMappingTypes())
+
+	_, err = NewCluster(nil, "x.y")
+	assert.Error(t, err)
+}
+
+func TestTypelessBulkBody(t *testing.T) {
+	body, err := typelessBulkBody("data", []*BulkDocument{
+		{ID: "1", Source: map[string]interface{}{"a": 1}},
+		{ID: "2", Source: map[string]interface{}{"a": 2}},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, `{"index":{"_id":"1","_index":"data"}}
+{"a":1}
+{"index":{"_id":"2","_index":"data"}}
+{"a":2}
+`, body)
+}
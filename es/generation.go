@@ -0,0 +1,344 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package es
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/unchartedsoftware/plog"
+	elastic "gopkg.in/olivere/elastic.v5"
+)
+
+const (
+	generationLayout = "20060102150405"
+)
+
+var (
+	generationSuffix = regexp.MustCompile(`^_[0-9]{17}$`)
+
+	// generatedSettings are the index settings set by Elasticsearch, which
+	// cannot be given when creating an index.
+	generatedSettings = map[string]bool{
+		"uuid":          true,
+		"version":       true,
+		"creation_date": true,
+		"provided_name": true,
+	}
+)
+
+// NewGeneration returns the name of a new concrete index to be exposed
+// through the alias. Generation names sort in creation order.
+func NewGeneration(alias string) string {
+	now := time.Now().UTC()
+	return fmt.Sprintf("%s_%s%03d", alias, now.Format(generationLayout), now.Nanosecond()/int(time.Millisecond))
+}
+
+// IsGeneration indicates whether or not the index is a generation of the
+// alias.
+func IsGeneration(alias string, index string) bool {
+	return len(index) > len(alias) && index[:len(alias)] == alias && generationSuffix.MatchString(index[len(alias):])
+}
+
+// Generations lists the generations of the alias, oldest first.
+func Generations(client *elastic.Client, alias string) ([]string, error) {
+	res, err := client.Aliases().Do(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list indices")
+	}
+
+	generations := make([]string, 0)
+	for index := range res.Indices {
+		if IsGeneration(alias, index) {
+			generations = append(generations, index)
+		}
+	}
+	sort.Strings(generations)
+
+	return generations, nil
+}
+
+// AliasIndices lists the concrete indices the alias points to.
+func AliasIndices(client *elastic.Client, alias string) ([]string, error) {
+	res, err := client.Aliases().Do(context.Background())
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to list aliases")
+	}
+
+	indices := res.IndicesByAlias(alias)
+	sort.Strings(indices)
+
+	return indices, nil
+}
+
+// CopyIndex creates the target index with the settings and mappings of the
+// source index, then copies the documents of the source into it. The target
+// is deleted if the copy is incomplete.
+func CopyIndex(client *elastic.Client, source string, target string) error {
+	settings, err := client.IndexGetSettings(source).Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to read settings of index %s", source)
+	}
+	mappings, err := client.GetMapping().Index(source).Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to read mappings of index %s", source)
+	}
+
+	var indexSettings map[string]interface{}
+	if settings[source] != nil {
+		indexSettings = creatableSettings(settings[source].Settings)
+	}
+	var indexMappings map[string]interface{}
+	if m, ok := mappings[source].(map[string]interface{}); ok {
+		indexMappings, _ = m["mappings"].(map[string]interface{})
+	}
+	err = createIndex(client, target, indexSettings, indexMappings)
+	if err != nil {
+		return err
+	}
+
+	expected, err := CountDocuments(client, source)
+	if err != nil {
+		DeleteIndex(client, target)
+		return err
+	}
+	_, err = client.Reindex().SourceIndex(source).DestinationIndex(target).Refresh("true").Do(context.Background())
+	if err != nil {
+		DeleteIndex(client, target)
+		return errors.Wrapf(err, "failed to copy index %s to %s", source, target)
+	}
+	count, err := CountDocuments(client, target)
+	if err != nil {
+		DeleteIndex(client, target)
+		return err
+	}
+	if count != expected {
+		DeleteIndex(client, target)
+		return fmt.Errorf("copied %d of the %d documents of index %s to %s", count, expected, source, target)
+	}
+
+	return nil
+}
+
+// creatableSettings removes the settings generated by Elasticsearch from the
+// settings read from an index.
+func creatableSettings(settings map[string]interface{}) map[string]interface{} {
+	index, ok := settings["index"].(map[string]interface{})
+	if !ok {
+		return settings
+	}
+
+	creatable := make(map[string]interface{})
+	for k, v := range index {
+		if !generatedSettings[k] {
+			creatable[k] = v
+		}
+	}
+	copied := make(map[string]interface{})
+	for k, v := range settings {
+		copied[k] = v
+	}
+	copied["index"] = creatable
+
+	return copied
+}
+
+// MigrateToAlias replaces a concrete index created before the indices were
+// versioned by an alias of the same name, pointing to a new generation
+// holding a copy of its documents. Nothing is done when the name is not a
+// concrete index.
+func MigrateToAlias(cluster Cluster, name string) error {
+	client := cluster.Client()
+	res, err := client.Aliases().Do(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "failed to list aliases")
+	}
+	if _, ok := res.Indices[name]; !ok {
+		return nil
+	}
+
+	generation := NewGeneration(name)
+	log.Infof("Copying index %s to %s to replace it with an alias", name, generation)
+	err = CopyIndex(client, name, generation)
+	if err != nil {
+		return err
+	}
+
+	err = replaceWithAlias(cluster, name, generation)
+	if err != nil {
+		DeleteIndex(client, generation)
+		return err
+	}
+
+	return nil
+}
+
+// replaceWithAlias deletes the concrete index and adds an alias of the same
+// name to the generation, in the same request on the versions supporting it.
+// The older versions leave the name missing until the alias is added.
+func replaceWithAlias(cluster Cluster, name string, generation string) error {
+	client := cluster.Client()
+
+	if versionAtLeast(cluster.Version(), 6, 4) {
+		body := map[string]interface{}{
+			"actions": []interface{}{
+				map[string]interface{}{
+					"add": map[string]interface{}{"index": generation, "alias": name},
+				},
+				map[string]interface{}{
+					"remove_index": map[string]interface{}{"index": name},
+				},
+			},
+		}
+		_, err := client.PerformRequest(context.Background(), "POST", "/_aliases", nil, body)
+		if err != nil {
+			return errors.Wrapf(err, "failed to replace index %s with an alias", name)
+		}
+		return nil
+	}
+
+	log.Warnf("Deleting index %s to replace it with an alias, Elasticsearch %s cannot do both at once", name, cluster.Version())
+	err := DeleteIndex(client, name)
+	if err != nil {
+		return err
+	}
+	added, err := client.Alias().Add(generation, name).Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to point alias %s to index %s", name, generation)
+	}
+	if !added.Acknowledged {
+		return fmt.Errorf("failed to point alias %s to index %s", name, generation)
+	}
+
+	return nil
+}
+
+// versionAtLeast indicates whether or not the version is the major and minor
+// version or a later one.
+func versionAtLeast(version string, major int, minor int) bool {
+	parts := strings.SplitN(version, ".", 3)
+	versionMajor, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	if versionMajor != major || len(parts) < 2 {
+		return versionMajor > major
+	}
+	versionMinor, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return false
+	}
+
+	return versionMinor >= minor
+}
+
+// CountDocuments refreshes the index, or alias, then counts its documents.
+func CountDocuments(client *elastic.Client, index string) (int64, error) {
+	_, err := client.Refresh(index).Do(context.Background())
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to refresh index %s", index)
+	}
+
+	count, err := client.Count(index).Do(context.Background())
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to count documents of index %s", index)
+	}
+
+	return count, nil
+}
+
+// DeleteIndex deletes a concrete index.
+func DeleteIndex(client *elastic.Client, index string) error {
+	deleted, err := client.DeleteIndex(index).Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to delete index %s", index)
+	}
+	if !deleted.Acknowledged {
+		return fmt.Errorf("failed to delete index %s", index)
+	}
+
+	return nil
+}
+
+// SwapAlias points the alias to the index, removing it from the indices it
+// previously targeted in the same atomic request. An alias cannot share the
+// name of a concrete index, so the indices created before the indices were
+// versioned need to be replaced by MigrateToAlias first.
+func SwapAlias(client *elastic.Client, alias string, index string) error {
+	res, err := client.Aliases().Do(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "failed to list aliases")
+	}
+
+	if _, ok := res.Indices[alias]; ok {
+		return errors.Errorf("index %s is not an alias, it needs to be migrated before ingesting a new generation", alias)
+	}
+
+	swap := client.Alias().Add(index, alias)
+	for _, previous := range res.IndicesByAlias(alias) {
+		if previous != index {
+			swap = swap.Remove(previous, alias)
+		}
+	}
+
+	log.Infof("Pointing alias %s to index %s", alias, index)
+	swapped, err := swap.Do(context.Background())
+	if err != nil {
+		return errors.Wrapf(err, "failed to point alias %s to index %s", alias, index)
+	}
+	if !swapped.Acknowledged {
+		return fmt.Errorf("failed to point alias %s to index %s", alias, index)
+	}
+
+	return nil
+}
+
+// PruneGenerations deletes the oldest generations of the alias, keeping the
+// specified number of the most recent ones. Generations targeted by the
+// alias are never deleted.
+func PruneGenerations(client *elastic.Client, alias string, retain int) error {
+	generations, err := Generations(client, alias)
+	if err != nil {
+		return err
+	}
+	if len(generations) <= retain {
+		return nil
+	}
+
+	res, err := client.Aliases().Do(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "failed to list aliases")
+	}
+
+	for _, index := range generations[:len(generations)-retain] {
+		if res.Indices[index].HasAlias(alias) {
+			continue
+		}
+		log.Infof("Deleting index %s from a previous generation of %s", index, alias)
+		err = DeleteIndex(client, index)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
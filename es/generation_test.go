@@ -0,0 +1,58 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package es
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneration(t *testing.T) {
+	generation := NewGeneration("datasets")
+	assert.True(t, IsGeneration("datasets", generation))
+	assert.False(t, IsGeneration("data", generation))
+	assert.False(t, IsGeneration("datasets", "datasets"))
+	assert.False(t, IsGeneration("datasets", "datasets_backup"))
+}
+
+func TestCreatableSettings(t *testing.T) {
+	settings := creatableSettings(map[string]interface{}{
+		"index": map[string]interface{}{
+			"number_of_shards": "5",
+			"uuid":             "abc",
+			"creation_date":    "1",
+			"provided_name":    "datasets",
+			"version":          map[string]interface{}{"created": "5060099"},
+			"analysis":         map[string]interface{}{},
+		},
+	})
+	assert.Equal(t, map[string]interface{}{
+		"index": map[string]interface{}{
+			"number_of_shards": "5",
+			"analysis":         map[string]interface{}{},
+		},
+	}, settings)
+}
+
+func TestVersionAtLeast(t *testing.T) {
+	assert.True(t, versionAtLeast("6.4.0", 6, 4))
+	assert.True(t, versionAtLeast("6.8.2", 6, 4))
+	assert.True(t, versionAtLeast("7.0.0", 6, 4))
+	assert.False(t, versionAtLeast("6.3.2", 6, 4))
+	assert.False(t, versionAtLeast("5.6.9", 6, 4))
+	assert.False(t, versionAtLeast("unknown", 6, 4))
+}
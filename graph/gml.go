@@ -0,0 +1,273 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package graph
+
+import (
+	"bufio"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// NodeIDAttribute is the attribute identifying the nodes of the D3M
+	// graphs, which the datasets refer to.
+	NodeIDAttribute = "nodeID"
+	// IDAttribute is the GML attribute identifying the nodes.
+	IDAttribute = "id"
+)
+
+// Graph holds the attributes of the nodes and edges of a GML graph. Nested
+// attributes are flattened into dotted names.
+type Graph struct {
+	Directed       bool
+	NodeAttributes []string
+	EdgeAttributes []string
+	Nodes          []map[string]string
+	Edges          []map[string]string
+}
+
+// NodeKey returns the attribute identifying the nodes, which is the D3M
+// node id when set on every node and the GML id otherwise.
+func (g *Graph) NodeKey() string {
+	if len(g.Nodes) == 0 {
+		return IDAttribute
+	}
+	for _, n := range g.Nodes {
+		if _, ok := n[NodeIDAttribute]; !ok {
+			return IDAttribute
+		}
+	}
+	return NodeIDAttribute
+}
+
+// ReadGML reads the graph of a GML file.
+func ReadGML(filename string) (*Graph, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to open graph file %s", filename)
+	}
+	defer file.Close()
+
+	g, err := ParseGML(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "failed to parse graph file %s", filename)
+	}
+	return g, nil
+}
+
+// ParseGML parses the first graph of a GML document.
+func ParseGML(r io.Reader) (*Graph, error) {
+	tokens, err := tokenize(r)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{
+		tokens: tokens,
+	}
+	for p.more() {
+		key := p.next()
+		if key != "graph" {
+			// skip the values outside of the graph, such as the creator.
+			err = p.skipValue()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if p.next() != "[" {
+			return nil, errors.New("expected a list after `graph`")
+		}
+		return p.parseGraph()
+	}
+
+	return nil, errors.New("no graph found")
+}
+
+type parser struct {
+	tokens []string
+	pos    int
+}
+
+func (p *parser) more() bool {
+	return p.pos < len(p.tokens)
+}
+
+func (p *parser) next() string {
+	if !p.more() {
+		return ""
+	}
+	token := p.tokens[p.pos]
+	p.pos = p.pos + 1
+	return token
+}
+
+func (p *parser) parseGraph() (*Graph, error) {
+	g := &Graph{
+		NodeAttributes: make([]string, 0),
+		EdgeAttributes: make([]string, 0),
+		Nodes:          make([]map[string]string, 0),
+		Edges:          make([]map[string]string, 0),
+	}
+	nodeAttributes := make(map[string]bool)
+	edgeAttributes := make(map[string]bool)
+
+	for p.more() {
+		key := p.next()
+		switch key {
+		case "]":
+			return g, nil
+		case "node", "edge":
+			if p.next() != "[" {
+				return nil, errors.Errorf("expected a list after `%s`", key)
+			}
+			attributes := make(map[string]string)
+			err := p.parseList("", attributes)
+			if err != nil {
+				return nil, err
+			}
+			if key == "node" {
+				g.Nodes = append(g.Nodes, attributes)
+				g.NodeAttributes = addAttributes(g.NodeAttributes, nodeAttributes, attributes)
+			} else {
+				g.Edges = append(g.Edges, attributes)
+				g.EdgeAttributes = addAttributes(g.EdgeAttributes, edgeAttributes, attributes)
+			}
+		case "directed":
+			g.Directed = p.next() == "1"
+		default:
+			err := p.skipValue()
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return nil, errors.New("unterminated graph")
+}
+
+// parseList reads the attributes of a list up to its closing bracket.
+func (p *parser) parseList(prefix string, attributes map[string]string) error {
+	for p.more() {
+		key := p.next()
+		if key == "]" {
+			return nil
+		}
+		if !p.more() {
+			break
+		}
+		value := p.next()
+		if value == "[" {
+			err := p.parseList(prefix+key+".", attributes)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		attributes[prefix+key] = value
+	}
+
+	return errors.New("unterminated list")
+}
+
+func (p *parser) skipValue() error {
+	if p.next() != "[" {
+		return nil
+	}
+	depth := 1
+	for p.more() && depth > 0 {
+		switch p.next() {
+		case "[":
+			depth = depth + 1
+		case "]":
+			depth = depth - 1
+		}
+	}
+	if depth > 0 {
+		return errors.New("unterminated list")
+	}
+	return nil
+}
+
+// addAttributes records the attributes not yet seen, in the order they are
+// first found.
+func addAttributes(names []string, seen map[string]bool, attributes map[string]string) []string {
+	added := make([]string, 0)
+	for name := range attributes {
+		if !seen[name] {
+			seen[name] = true
+			added = append(added, name)
+		}
+	}
+	// map iteration is random so the new attributes of an element are sorted.
+	sort.Strings(added)
+	return append(names, added...)
+}
+
+// tokenize splits a GML document into keys, values and brackets. Quotes
+// are removed from the strings and comments are skipped.
+func tokenize(r io.Reader) ([]string, error) {
+	tokens := make([]string, 0)
+	reader := bufio.NewReader(r)
+
+	var current strings.Builder
+	flush := func() {
+		if current.Len() > 0 {
+			tokens = append(tokens, current.String())
+			current.Reset()
+		}
+	}
+
+	for {
+		c, _, err := reader.ReadRune()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to read graph")
+		}
+
+		switch {
+		case c == '"':
+			flush()
+			value, err := reader.ReadString('"')
+			if err != nil {
+				return nil, errors.New("unterminated string")
+			}
+			tokens = append(tokens, value[:len(value)-1])
+		case c == '#' && current.Len() == 0:
+			_, err := reader.ReadString('\n')
+			if err != nil && err != io.EOF {
+				return nil, errors.Wrap(err, "failed to read graph")
+			}
+		case c == '[' || c == ']':
+			flush()
+			tokens = append(tokens, string(c))
+		case unicode.IsSpace(c):
+			flush()
+		default:
+			current.WriteRune(c)
+		}
+	}
+	flush()
+
+	return tokens, nil
+}
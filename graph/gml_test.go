@@ -0,0 +1,37 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package graph
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadGML(t *testing.T) {
+	g, err := ReadGML("./testdata/graph.gml")
+	assert.NoError(t, err)
+
+	assert.True(t, g.Directed)
+	assert.Equal(t, []string{"graphics.x", "id", "label", "nodeID", "weight"}, g.NodeAttributes)
+	assert.Equal(t, []string{"source", "target", "weight"}, g.EdgeAttributes)
+	assert.Equal(t, 2, len(g.Nodes))
+	assert.Equal(t, "first node", g.Nodes[0]["label"])
+	assert.Equal(t, "1.5", g.Nodes[0]["graphics.x"])
+	assert.Equal(t, "", g.Nodes[0]["weight"])
+	assert.Equal(t, "0.5", g.Edges[0]["weight"])
+	assert.Equal(t, NodeIDAttribute, g.NodeKey())
+}
@@ -0,0 +1,24 @@
+Creator "test"
+graph [
+  directed 1
+  # nodes of the graph
+  node [
+    id 0
+    nodeID 10
+    label "first node"
+    graphics [
+      x 1.5
+    ]
+  ]
+  node [
+    id 1
+    nodeID 11
+    label "second node"
+    weight 2
+  ]
+  edge [
+    source 0
+    target 1
+    weight 0.5
+  ]
+]
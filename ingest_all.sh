@@ -9,6 +9,7 @@ SUMMARY_MACHINE=/data/summary-machine.json
 IMPORTANCE=/data/importance.json
 METADATA_INDEX=datasets
 DATASETS=(r_26 r_27 r_32 r_60 o_185 o_196 o_313 o_38 o_4550)
+SKIPPED=()
 ES_ENDPOINT=http://localhost:9200
 
 for DATASET in "${DATASETS[@]}"
@@ -16,6 +17,13 @@ do
     echo "--------------------------------------------------------------------------------"
     echo " Ingesting $DATASET dataset"
     echo "--------------------------------------------------------------------------------"
+    # only the errors of the schema fail the validation, the warnings are logged
+    if ! go run cmd/distil-validate/main.go \
+        --schema="$DATA_DIR/$DATASET/$SCHEMA"; then
+        echo "Skipping $DATASET dataset, its schema is invalid"
+        SKIPPED+=("$DATASET")
+        continue
+    fi
     go run cmd/distil-ingest/main.go \
         --es-endpoint="$ES_ENDPOINT" \
         --es-metadata-index="$METADATA_INDEX" \
@@ -29,3 +37,8 @@ do
         --clear-existing \
         --include-raw-dataset
 done
+
+if [ ${#SKIPPED[@]} -gt 0 ]; then
+    echo "Skipped ${#SKIPPED[@]} datasets with an invalid schema: ${SKIPPED[*]}"
+    exit 1
+fi
@@ -27,10 +27,13 @@ import (
 	"github.com/pkg/errors"
 
 	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/graph"
 )
 
 const (
-	d3mIndexName = "d3mIndex"
+	d3mIndexName  = "d3mIndex"
+	resTypeGraph  = "graph"
+	resObjectNode = "node"
 )
 
 // FileLink represents a link between a dataset col and a file.
@@ -106,6 +109,68 @@ func readFileLink(dataResource *model.DataResource, indexVarName string, filenam
 	}, nil
 }
 
+// nodeReference is a column holding the ids of the nodes of a graph.
+type nodeReference struct {
+	resID    string
+	graphID  string
+	variable *model.Variable
+}
+
+// readGraphLink reads the attributes of the nodes of a graph, indexed by the
+// node id the datasets refer to. The variables of the attributes are copies
+// of the graph variables prefixed with the name of the referencing column,
+// as several columns can refer to the same graph.
+func readGraphLink(dataResource *model.DataResource, prefix string, filename string) (*FileLink, error) {
+	g, err := graph.ReadGML(filename)
+	if err != nil {
+		return nil, err
+	}
+	key := g.NodeKey()
+
+	resourceVariables := make(map[string]*model.Variable)
+	for _, variable := range dataResource.Variables {
+		resourceVariables[variable.Name] = variable
+	}
+
+	var indexVar *model.Variable
+	header := make([]string, 0)
+	variables := make([]*model.Variable, 0)
+	for _, name := range g.NodeAttributes {
+		variable := resourceVariables[name]
+		if variable == nil {
+			continue
+		}
+		if name == key {
+			indexVar = variable
+			continue
+		}
+		header = append(header, name)
+		clone := *variable
+		clone.Name = fmt.Sprintf("%s_%s", prefix, variable.Name)
+		if clone.DisplayName != "" {
+			clone.DisplayName = fmt.Sprintf("%s_%s", prefix, variable.DisplayName)
+		}
+		variables = append(variables, &clone)
+	}
+
+	lookup := make(map[string][]string)
+	for _, node := range g.Nodes {
+		row := make([]string, len(header))
+		for i, name := range header {
+			row[i] = node[name]
+		}
+		lookup[node[key]] = row
+	}
+
+	return &FileLink{
+		Name:      filename,
+		IndexVar:  indexVar,
+		Lookup:    lookup,
+		Header:    header,
+		Variables: variables,
+	}, nil
+}
+
 // InjectFileLinksFromFile traverses all file links and injests the relevant data.
 func InjectFileLinksFromFile(meta *model.Metadata, inputFilename string, rawDataPath string, mergedDataPath string, hasHeader bool) (*model.DataResource, []byte, error) {
 	// need to skip the header row.
@@ -162,15 +227,18 @@ func InjectFileLinks(meta *model.Metadata, merged []byte, rawDataPath string, me
 	indexColumns := make(map[string]*model.Variable)
 	keyColumns := make([]*model.Variable, 0)
 	references := make(map[string]map[string]interface{})
+	nodeReferences := make([]*nodeReference, 0)
+	mainResID := ""
 	for _, dr := range meta.DataResources {
 		dataResources[dr.ResID] = dr
 		for i := 0; i < len(dr.Variables); i++ {
 			variable := dr.Variables[i]
 			isKey := false
 			for _, r := range variable.Role {
-				if r == "index" {
+				if r == "index" || r == "multiIndex" {
 					indexColumns[variable.Name] = variable
 					if variable.Name == d3mIndexName {
+						mainResID = dr.ResID
 						mergedDataResource.Variables = dr.Variables
 						mergedDataResource.ResType = dr.ResType
 						mergedDataResource.ResFormat = dr.ResFormat
@@ -190,10 +258,18 @@ func InjectFileLinks(meta *model.Metadata, merged []byte, rawDataPath string, me
 					obj, ok := variable.RefersTo["resObject"].(map[string]interface{})
 					if !ok {
 						// check if it is a string which does not refer to another resource
-						_, ok := variable.RefersTo["resObject"].(string)
+						obj, ok := variable.RefersTo["resObject"].(string)
 						if !ok {
 							return nil, nil, errors.Errorf("failed to parse reference for %s", variable.Name)
 						}
+						graphID, ok := variable.RefersTo["resID"].(string)
+						if ok && obj == resObjectNode {
+							nodeReferences = append(nodeReferences, &nodeReference{
+								resID:    dr.ResID,
+								graphID:  graphID,
+								variable: variable,
+							})
+						}
 					} else {
 						// Some datasets point to a resource rather than column
 						// Ignore those references
@@ -235,6 +311,25 @@ func InjectFileLinks(meta *model.Metadata, merged []byte, rawDataPath string, me
 		}
 	}
 
+	// denormalize the attributes of the nodes referred to by the main table
+	nodeColumns := make([]*model.Variable, 0)
+	nodeLinks := make(map[string]*FileLink)
+	for _, ref := range nodeReferences {
+		res := dataResources[ref.graphID]
+		if ref.resID != mainResID || res == nil || res.ResType != resTypeGraph {
+			continue
+		}
+
+		l, err := readGraphLink(res, ref.variable.Name, fmt.Sprintf("%s/%s", rawDataPath, res.ResPath))
+		if err != nil {
+			return nil, nil, errors.Wrapf(err, "failed read graph link %s from resource %s", res.ResPath, res.ResID)
+		}
+		nodeLinks[ref.variable.Name] = l
+		nodeColumns = append(nodeColumns, ref.variable)
+
+		mergedDataResource.Variables = append(mergedDataResource.Variables, l.Variables...)
+	}
+
 	// adjust variable indices
 	for i, v := range mergedDataResource.Variables {
 		v.Index = i
@@ -271,6 +366,16 @@ func InjectFileLinks(meta *model.Metadata, merged []byte, rawDataPath string, me
 			line = append(line, linkedRow...)
 		}
 
+		// process each node reference, leaving the attributes of unknown nodes empty
+		for _, node := range nodeColumns {
+			link := nodeLinks[node.Name]
+			linkedRow, ok := link.Lookup[line[node.Index]]
+			if !ok {
+				linkedRow = make([]string, len(link.Header))
+			}
+			line = append(line, linkedRow...)
+		}
+
 		// write the output
 		writer.Write(line)
 		count++
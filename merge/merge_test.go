@@ -21,6 +21,8 @@ import (
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+
+	"github.com/uncharted-distil/distil-compute/model"
 )
 
 func TestGetD3MIndices(t *testing.T) {
@@ -49,3 +51,60 @@ func TestLeftJoin(t *testing.T) {
 	assert.Equal(t, "20,1,200.0,2,2.0", lines[1])
 
 }
+
+func TestInjectGraphNodes(t *testing.T) {
+	nodeID := &model.Variable{
+		Name: "nodeID",
+		Role: []string{"attribute"},
+		RefersTo: map[string]interface{}{
+			"resID":     "G1",
+			"resObject": "node",
+		},
+	}
+	targetID := &model.Variable{
+		Name: "targetID",
+		Role: []string{"attribute"},
+		RefersTo: map[string]interface{}{
+			"resID":     "G1",
+			"resObject": "node",
+		},
+	}
+	meta := &model.Metadata{
+		DataResources: []*model.DataResource{
+			{
+				ResID:   "G1",
+				ResType: "graph",
+				ResPath: "graphs/G1.gml",
+				Variables: []*model.Variable{
+					{Name: "id", Role: []string{"attribute"}},
+					{Name: "label", Role: []string{"attribute"}},
+					{Name: "nodeID", Role: []string{"index"}},
+				},
+			},
+			{
+				ResID:   "learningData",
+				ResType: "table",
+				Variables: []*model.Variable{
+					{Name: "d3mIndex", Role: []string{"index"}},
+					nodeID,
+					targetID,
+				},
+			},
+		},
+	}
+
+	dr, output, err := InjectFileLinks(meta, []byte("0,10,11\n1,12,10\n"), "testdata", "merged.csv")
+	assert.NoError(t, err)
+
+	names := make([]string, len(dr.Variables))
+	for i, v := range dr.Variables {
+		names[i] = v.Name
+		assert.Equal(t, i, v.Index)
+	}
+	assert.Equal(t, []string{"d3mIndex", "nodeID", "targetID", "nodeID_id", "nodeID_label", "targetID_id", "targetID_label"}, names)
+	assert.Equal(t, "0,10,11,0,ten,1,eleven\n1,12,10,,,0,ten\n", string(output))
+
+	// the variables of the graph are left untouched
+	assert.Equal(t, "id", meta.DataResources[0].Variables[0].Name)
+	assert.Equal(t, "label", meta.DataResources[0].Variables[1].Name)
+}
@@ -8,6 +8,7 @@ OUTPUT_PATH=tables/merged.csv
 OUTPUT_PATH_HEADER=tables/merged_header.csv
 DATASET_FOLDER_SUFFIX=_dataset
 DATASETS=(26_radon_seed 32_wikiqa 60_jester 185_baseball 196_autoMpg 313_spectrometer 38_sick 1491_one_hundred_plants_margin 27_wordLevels 57_hypothyroid 299_libras_move 534_cps_85_wages 1567_poker_hand 22_handgeometry)
+SKIPPED=()
 HAS_HEADER=1
 
 for DATASET in "${DATASETS[@]}"
@@ -15,6 +16,13 @@ do
     echo "--------------------------------------------------------------------------------"
     echo " Merging $DATASET dataset"
     echo "--------------------------------------------------------------------------------"
+    # only the errors of the schema fail the validation, the warnings are logged
+    if ! go run cmd/distil-validate/main.go \
+        --schema="$DATA_DIR/${DATASET}/TRAIN/dataset_TRAIN/$SCHEMA"; then
+        echo "Skipping $DATASET dataset, its schema is invalid"
+        SKIPPED+=("$DATASET")
+        continue
+    fi
     go run cmd/distil-merge/main.go \
         --schema="$DATA_DIR/${DATASET}/TRAIN/dataset_TRAIN/$SCHEMA" \
         --data="$DATA_DIR/${DATASET}/TRAIN/dataset_TRAIN/$DATA_PATH" \
@@ -25,3 +33,8 @@ do
         --output-schema-path="$DATA_DIR/${DATASET}/TRAIN/dataset_TRAIN/$OUTPUT_SCHEMA" \
         --has-header=$HAS_HEADER
 done
+
+if [ ${#SKIPPED[@]} -gt 0 ]; then
+    echo "Skipped ${#SKIPPED[@]} datasets with an invalid schema: ${SKIPPED[*]}"
+    exit 1
+fi
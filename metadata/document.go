@@ -0,0 +1,123 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metadata
+
+import (
+	"reflect"
+	"strings"
+
+	"github.com/jeffail/gabs"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+var (
+	// resourceKeys and variableKeys are the keys of the schema documents
+	// written from the model, the others are copied from the original
+	// schema.
+	resourceKeys = modelKeys(model.DataResource{})
+	variableKeys = modelKeys(model.Variable{})
+)
+
+// modelKeys lists the JSON keys of the fields of a model struct.
+func modelKeys(v interface{}) map[string]bool {
+	keys := make(map[string]bool)
+	t := reflect.TypeOf(v)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("json"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = t.Field(i).Name
+		}
+		keys[name] = true
+	}
+	return keys
+}
+
+// mergeDocument overlays the generated document on a copy of the original
+// one, so that the keys unknown to the model are kept. The owned keys
+// missing from the generated document are dropped, as they were cleared
+// from the model.
+func mergeDocument(original interface{}, generated map[string]interface{}, owned map[string]bool) map[string]interface{} {
+	merged := make(map[string]interface{})
+	if doc, ok := original.(map[string]interface{}); ok {
+		for k, v := range doc {
+			if !owned[k] {
+				merged[k] = v
+			}
+		}
+	}
+	for k, v := range generated {
+		merged[k] = v
+	}
+	return merged
+}
+
+// schemaColumns indexes the columns of the resources of the schema by name.
+// The first column of a name is kept when the resource id is empty, which
+// searches every resource.
+func schemaColumns(schema *gabs.Container, resID string) map[string]*gabs.Container {
+	columns := make(map[string]*gabs.Container)
+	if schema == nil {
+		return columns
+	}
+
+	resources, _ := schema.Path("dataResources").Children()
+	for _, res := range resources {
+		if id, _ := res.Path("resID").Data().(string); resID != "" && id != resID {
+			continue
+		}
+		cols, _ := res.Path("columns").Children()
+		for _, col := range cols {
+			name, ok := col.Path("colName").Data().(string)
+			if ok && columns[name] == nil {
+				columns[name] = col
+			}
+		}
+	}
+	return columns
+}
+
+// schemaDocument builds the schema document of the metadata from the about
+// fields and data resources, keeping the other keys of the original schema.
+// The license is only defaulted when the original schema has none.
+func schemaDocument(m *model.Metadata, about map[string]interface{}, dataResources []interface{}) map[string]interface{} {
+	var original interface{}
+	var originalAbout interface{}
+	if m.Schema != nil {
+		original = m.Schema.Data()
+		originalAbout = m.Schema.Path("about").Data()
+	}
+
+	owned := make(map[string]bool)
+	for k := range about {
+		owned[k] = true
+	}
+	mergedAbout := mergeDocument(originalAbout, about, owned)
+	if _, ok := mergedAbout["license"]; !ok {
+		mergedAbout["license"] = license
+	}
+
+	return mergeDocument(original, map[string]interface{}{
+		"about":         mergedAbout,
+		"dataResources": dataResources,
+	}, map[string]bool{
+		"about":         true,
+		"dataResources": true,
+	})
+}
@@ -0,0 +1,79 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metadata
+
+import (
+	"fmt"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+// GeoPair is a latitude and longitude pair of variables locating the values
+// of a source variable.
+type GeoPair struct {
+	Name      string
+	Source    string
+	Latitude  *model.Variable
+	Longitude *model.Variable
+}
+
+// MarkGeoPair marks a latitude and longitude pair as the coordinates of the
+// source variable, through their type and original variable. The distil
+// role is left as is, so that the geocoded variables remain metadata. The
+// source is not referenced as the pair is not a foreign key.
+func MarkGeoPair(source string, lat *model.Variable, lon *model.Variable) {
+	lat.Type = model.LatitudeType
+	lat.OriginalVariable = source
+	lon.Type = model.LongitudeType
+	lon.OriginalVariable = source
+}
+
+// GeoPairs returns the latitude and longitude pairs marked in the variables.
+// Coordinates read from the dataset are not paired, as they are their own
+// original variable.
+func GeoPairs(variables []*model.Variable) []*GeoPair {
+	longitudes := make(map[string]*model.Variable)
+	for _, v := range variables {
+		if v.Type == model.LongitudeType && isGeoPairMarked(v) {
+			longitudes[v.OriginalVariable] = v
+		}
+	}
+
+	pairs := make([]*GeoPair, 0)
+	for _, v := range variables {
+		if v.Type != model.LatitudeType || !isGeoPairMarked(v) {
+			continue
+		}
+		source := v.OriginalVariable
+		if longitudes[source] == nil {
+			continue
+		}
+		pairs = append(pairs, &GeoPair{
+			Name:      fmt.Sprintf("_geo_%s", source),
+			Source:    source,
+			Latitude:  v,
+			Longitude: longitudes[source],
+		})
+	}
+
+	return pairs
+}
+
+// isGeoPairMarked indicates whether or not the coordinate variable was
+// derived from another variable.
+func isGeoPairMarked(v *model.Variable) bool {
+	return v.OriginalVariable != "" && v.OriginalVariable != v.Name
+}
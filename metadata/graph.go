@@ -0,0 +1,154 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metadata
+
+import (
+	"path"
+	"strconv"
+
+	"github.com/jeffail/gabs"
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/graph"
+)
+
+const (
+	resTypeGraph    = "graph"
+	resTypeEdgeList = "edgeList"
+
+	// EdgeVariablePrefix prefixes the variables of the edge attributes of a
+	// graph, to tell them from the node attributes.
+	EdgeVariablePrefix = "edge_"
+)
+
+func init() {
+	RegisterDataResourceParser(resTypeGraph, func(resType string, rootPath string) DataResourceParser {
+		return &Graph{
+			rootPath: rootPath,
+		}
+	})
+	RegisterDataResourceParser(resTypeEdgeList, func(resType string, rootPath string) DataResourceParser {
+		return &EdgeList{}
+	})
+}
+
+// Graph is a data resource that is backed by a GML file. The attributes of
+// the nodes and edges are loaded as variables.
+type Graph struct {
+	rootPath string
+}
+
+// Parse extracts the data resource from the data schema document.
+func (r *Graph) Parse(res *gabs.Container) (*model.DataResource, error) {
+	if res.Path("resID").Data() == nil {
+		return nil, errors.Errorf("unable to parse resource id")
+	}
+	resID := res.Path("resID").Data().(string)
+
+	if res.Path("resPath").Data() == nil {
+		return nil, errors.Errorf("unable to parse resource path")
+	}
+	resPath := res.Path("resPath").Data().(string)
+
+	resFormats, err := parseResourceFormats(res)
+	if err != nil {
+		return nil, err
+	}
+
+	g, err := graph.ReadGML(path.Join(r.rootPath, resPath))
+	if err != nil {
+		return nil, err
+	}
+
+	dr := &model.DataResource{
+		ResID:        resID,
+		ResPath:      resPath,
+		ResType:      resTypeGraph,
+		ResFormat:    resFormats,
+		IsCollection: false,
+		Variables:    make([]*model.Variable, 0),
+	}
+
+	nodeKey := g.NodeKey()
+	for _, name := range g.NodeAttributes {
+		role := "attribute"
+		if name == nodeKey {
+			role = "index"
+		}
+		dr.Variables = append(dr.Variables, graphVariable(dr.Variables, name, role, g.Nodes))
+	}
+	for _, name := range g.EdgeAttributes {
+		v := graphVariable(dr.Variables, EdgeVariablePrefix+name, "attribute", g.Edges)
+		v.OriginalVariable = name
+		dr.Variables = append(dr.Variables, v)
+	}
+
+	return dr, nil
+}
+
+// EdgeList is a table of the edges of a graph, with the columns of the
+// source and target nodes referring to the nodes.
+type EdgeList struct {
+}
+
+// Parse extracts the data resource from the data schema document.
+func (r *EdgeList) Parse(res *gabs.Container) (*model.DataResource, error) {
+	dr, err := (&Table{}).Parse(res)
+	if err != nil {
+		return nil, err
+	}
+	dr.ResType = resTypeEdgeList
+
+	return dr, nil
+}
+
+// graphVariable creates the variable of an attribute, typed from the values
+// it takes on the elements of the graph.
+func graphVariable(existing []*model.Variable, name string, role string, elements []map[string]string) *model.Variable {
+	typ := attributeType(name, elements)
+	return model.NewVariable(
+		len(existing),
+		name,
+		"",
+		name,
+		typ,
+		typ,
+		[]string{role},
+		model.VarRoleData,
+		nil,
+		existing,
+		false)
+}
+
+func attributeType(name string, elements []map[string]string) string {
+	typ := model.IntegerType
+	for _, e := range elements {
+		value, ok := e[name]
+		if !ok || value == "" {
+			continue
+		}
+		if _, err := strconv.ParseInt(value, 10, 64); err == nil {
+			continue
+		}
+		if _, err := strconv.ParseFloat(value, 64); err == nil {
+			typ = model.FloatType
+			continue
+		}
+		return model.TextType
+	}
+	return typ
+}
@@ -0,0 +1,315 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-ingest/es"
+)
+
+const (
+	metadataDocType = "metadata"
+)
+
+var (
+	// metadataFields lists the fields of the documents written by
+	// IngestMetadata. The fields of the objects are named by their dotted
+	// path, after the object itself.
+	metadataFields = documentFields()
+)
+
+// IndexTemplate holds the settings of the metadata index and the properties
+// of its documents. The properties are wrapped into the mappings matching the
+// version of the cluster when the index is created.
+type IndexTemplate struct {
+	Settings   map[string]interface{} `json:"settings"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// LoadIndexTemplate reads an index template from a JSON file.
+func LoadIndexTemplate(path string) (*IndexTemplate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to read index template")
+	}
+
+	template := &IndexTemplate{}
+	err = json.Unmarshal(data, template)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse index template")
+	}
+	if template.Properties == nil {
+		return nil, errors.Errorf("index template %s has no properties", path)
+	}
+
+	return template, nil
+}
+
+// DefaultIndexTemplate returns the template used when none is provided.
+func DefaultIndexTemplate(cluster es.Cluster) *IndexTemplate {
+	return &IndexTemplate{
+		Settings:   metadataSettings(),
+		Properties: metadataProperties(cluster),
+	}
+}
+
+// Validate checks that every field of the metadata documents is mapped,
+// including the fields of the variables and their statistics. The fields of
+// an object which is not mapped are not reported along with it.
+func (t *IndexTemplate) Validate() error {
+	missing := make([]string, 0)
+	for _, field := range metadataFields {
+		if isMappedField(t.Properties, field) || hasMissingParent(missing, field) {
+			continue
+		}
+		missing = append(missing, field)
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return errors.Errorf("index template is missing the fields %v", missing)
+	}
+
+	return nil
+}
+
+// documentFields lists the fields of the metadata documents. The variables
+// are written from the model, without their references which are cleared.
+func documentFields() []string {
+	fields := []string{
+		"datasetName",
+		"datasetID",
+		"storageName",
+		"description",
+		"summary",
+		"summaryMachine",
+		"numRows",
+		"numBytes",
+		"numColumns",
+		"numMalformedRows",
+		"encoding",
+		"variables",
+		"datasetFolder",
+		"source",
+	}
+	fields = append(fields, objectFields("variables", variableKeys, "refersTo")...)
+	fields = append(fields, "variables.colTypedName")
+	fields = append(fields, "variables.stats")
+	fields = append(fields, objectFields("variables.stats", modelKeys(VariableStats{}))...)
+	fields = append(fields, objectFields("variables.stats.topValues", modelKeys(ValueCount{}))...)
+
+	return fields
+}
+
+// objectFields names the fields of an object by their dotted path, in
+// order.
+func objectFields(object string, keys map[string]bool, excluded ...string) []string {
+	skipped := make(map[string]bool)
+	for _, key := range excluded {
+		skipped[key] = true
+	}
+
+	fields := make([]string, 0, len(keys))
+	for key := range keys {
+		if !skipped[key] {
+			fields = append(fields, object+"."+key)
+		}
+	}
+	sort.Strings(fields)
+
+	return fields
+}
+
+// isMappedField indicates whether or not the properties map the field,
+// descending into the properties of the objects along its dotted path.
+func isMappedField(properties map[string]interface{}, field string) bool {
+	names := strings.Split(field, ".")
+	for _, name := range names[:len(names)-1] {
+		object, _ := properties[name].(map[string]interface{})
+		properties, _ = object["properties"].(map[string]interface{})
+	}
+
+	_, ok := properties[names[len(names)-1]]
+	return ok
+}
+
+func hasMissingParent(missing []string, field string) bool {
+	for _, parent := range missing {
+		if strings.HasPrefix(field, parent+".") {
+			return true
+		}
+	}
+	return false
+}
+
+// mappings wraps the template properties for the cluster, removing the
+// options it does not support.
+func (t *IndexTemplate) mappings(cluster es.Cluster) map[string]interface{} {
+	properties := t.Properties
+	if !cluster.SupportsAllField() {
+		properties = withoutAllField(properties)
+	}
+
+	return cluster.Mappings(metadataDocType, properties)
+}
+
+func withoutAllField(properties map[string]interface{}) map[string]interface{} {
+	stripped := make(map[string]interface{})
+	for k, v := range properties {
+		if k == "include_in_all" {
+			continue
+		}
+		if m, ok := v.(map[string]interface{}); ok {
+			v = withoutAllField(m)
+		}
+		stripped[k] = v
+	}
+
+	return stripped
+}
+
+// metadataSettings defines an ngram analyzer applied to the variable names
+// to allow for substring searching.
+func metadataSettings() map[string]interface{} {
+	return map[string]interface{}{
+		"analysis": map[string]interface{}{
+			"filter": map[string]interface{}{
+				"ngram_filter": map[string]interface{}{
+					"type":     "ngram",
+					"min_gram": 4,
+					"max_gram": 20,
+				},
+				"search_filter": map[string]interface{}{
+					"type":     "edge_ngram",
+					"min_gram": 1,
+					"max_gram": 20,
+				},
+			},
+			"analyzer": map[string]interface{}{
+				"ngram_analyzer": map[string]interface{}{
+					"type":      "custom",
+					"tokenizer": "standard",
+					"filter":    []string{"lowercase", "ngram_filter"},
+				},
+				"search_analyzer": map[string]interface{}{
+					"type":      "custom",
+					"tokenizer": "standard",
+					"filter":    []string{"lowercase", "search_filter"},
+				},
+			},
+		},
+	}
+}
+
+// metadataProperties generates the properties of the metadata documents for
+// the version of the cluster.
+func metadataProperties(cluster es.Cluster) map[string]interface{} {
+	field := func(typ string) map[string]interface{} {
+		return map[string]interface{}{
+			"type": typ,
+		}
+	}
+	searchField := func() map[string]interface{} {
+		f := field("text")
+		f["analyzer"] = "search_analyzer"
+		return f
+	}
+	nameField := func() map[string]interface{} {
+		f := searchField()
+		f["term_vector"] = "yes"
+		if cluster.SupportsAllField() {
+			f["include_in_all"] = true
+		}
+		return f
+	}
+
+	keywordField := func(f map[string]interface{}) map[string]interface{} {
+		f["fields"] = map[string]interface{}{
+			"keyword": map[string]interface{}{
+				"type":         "keyword",
+				"ignore_above": 256,
+			},
+		}
+		return f
+	}
+
+	properties := map[string]interface{}{
+		"datasetID":        keywordField(field("text")),
+		"datasetName":      keywordField(searchField()),
+		"storageName":      field("text"),
+		"datasetFolder":    field("text"),
+		"description":      searchField(),
+		"summary":          searchField(),
+		"summaryMachine":   searchField(),
+		"numRows":          field("long"),
+		"numBytes":         field("long"),
+		"numColumns":       field("long"),
+		"numMalformedRows": field("long"),
+		"encoding":         field("keyword"),
+		"source":           keywordField(field("text")),
+		"variables": map[string]interface{}{
+			"properties": map[string]interface{}{
+				"colName":         keywordField(nameField()),
+				"colTypedName":    field("keyword"),
+				"colDisplayName":  field("text"),
+				"colDescription":  field("text"),
+				"colType":         keywordField(field("text")),
+				"colOriginalType": field("text"),
+				"colIndex":        field("integer"),
+				"role":            field("text"),
+				"distilRole":      field("keyword"),
+				"selectedRole":    field("keyword"),
+				"varOriginalName": field("text"),
+				"importance":      field("integer"),
+				"suggestedTypes": map[string]interface{}{
+					"properties": map[string]interface{}{
+						"type":        field("keyword"),
+						"probability": field("double"),
+						"provenance":  field("keyword"),
+					},
+				},
+				"stats": map[string]interface{}{
+					"properties": map[string]interface{}{
+						"count":          field("long"),
+						"nullCount":      field("long"),
+						"distinctCount":  field("long"),
+						"distinctCapped": field("boolean"),
+						"min":            field("double"),
+						"max":            field("double"),
+						"mean":           field("double"),
+						"stddev":         field("double"),
+						"minLength":      field("integer"),
+						"maxLength":      field("integer"),
+						"meanLength":     field("double"),
+						"topValues": map[string]interface{}{
+							"properties": map[string]interface{}{
+								"value": field("keyword"),
+								"count": field("long"),
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
+	return properties
+}
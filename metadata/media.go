@@ -19,11 +19,30 @@ import (
 	"fmt"
 
 	"github.com/jeffail/gabs"
-	"github.com/pkg/errors"
 
 	"github.com/uncharted-distil/distil-compute/model"
 )
 
+func init() {
+	for _, resType := range []string{model.ResTypeAudio, model.ResTypeImage, model.ResTypeText, resTypeVideo, resTypeSpeech} {
+		RegisterDataResourceParser(resType, func(resType string, rootPath string) DataResourceParser {
+			return NewMedia(resType)
+		})
+	}
+}
+
+// defaultsToCollection indicates whether or not a resource of the type which
+// does not state whether it is a collection is parsed as one, as the media
+// resources are.
+func defaultsToCollection(resType string, rootPath string, options *loadOptions) bool {
+	parser, err := newDataResourceParser(resType, rootPath, options)
+	if err != nil {
+		return false
+	}
+	_, ok := parser.(*Media)
+	return ok
+}
+
 // Media is a data resource that is backed by media files.
 type Media struct {
 	Type string
@@ -48,25 +67,22 @@ func (r *Media) Parse(res *gabs.Container) (*model.DataResource, error) {
 	}
 	resPath := res.Path("resPath").Data().(string)
 
-	var resFormats []string
-	if res.Path("resFormat").Data() != nil {
-		formatsRaw, err := res.Path("resFormat").Children()
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to parse resource format")
-		}
-		resFormats = make([]string, len(formatsRaw))
-		for i, r := range formatsRaw {
-			resFormats[i] = r.Data().(string)
-		}
-	} else {
-		resFormats = make([]string, 0)
+	resFormats, err := parseResourceFormats(res)
+	if err != nil {
+		return nil, err
+	}
+
+	// media resources are collections of files unless stated otherwise.
+	isCollection := true
+	if collection, ok := res.Path("isCollection").Data().(bool); ok {
+		isCollection = collection
 	}
 
 	dr := &model.DataResource{
 		ResID:        resID,
 		ResPath:      resPath,
 		ResType:      r.Type,
-		IsCollection: true,
+		IsCollection: isCollection,
 		ResFormat:    resFormats,
 	}
 
@@ -16,12 +16,12 @@
 package metadata
 
 import (
+	"bufio"
 	"bytes"
 	"context"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"os"
 	"path"
@@ -29,23 +29,36 @@ import (
 	"regexp"
 	"sort"
 	"strings"
+	"unicode/utf8"
 
 	"github.com/jeffail/gabs"
 	"github.com/pkg/errors"
 	log "github.com/unchartedsoftware/plog"
-	elastic "gopkg.in/olivere/elastic.v5"
 
 	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/es"
 	"github.com/uncharted-distil/distil-ingest/rest"
 	"github.com/uncharted-distil/distil-ingest/smmry"
 )
 
+var (
+	utf8BOM    = []byte{0xEF, 0xBB, 0xBF}
+	utf16LEBOM = []byte{0xFF, 0xFE}
+	utf16BEBOM = []byte{0xFE, 0xFF}
+)
+
 // DatasetSource flags the type of ingest action that created a dataset
 type DatasetSource string
 
 const (
 	datasetSuffix = "_dataset"
 
+	encodingUTF8    = "utf-8"
+	encodingUTF8BOM = "utf-8-bom"
+	encodingUTF16LE = "utf-16le"
+	encodingUTF16BE = "utf-16be"
+	encodingUnknown = "unknown"
+
 	// ProvenanceSimon identifies the type provenance as Simon
 	ProvenanceSimon = "d3m.primitives.distil.simon"
 	// ProvenanceSchema identifies the type provenance as schema
@@ -87,7 +100,7 @@ func IsMetadataVariable(v *model.Variable) bool {
 }
 
 // LoadMetadataFromOriginalSchema loads metadata from a schema file.
-func LoadMetadataFromOriginalSchema(schemaPath string) (*model.Metadata, error) {
+func LoadMetadataFromOriginalSchema(schemaPath string, options ...LoadOption) (*model.Metadata, error) {
 	meta := &model.Metadata{
 		SchemaSource: model.SchemaSourceOriginal,
 	}
@@ -95,6 +108,10 @@ func LoadMetadataFromOriginalSchema(schemaPath string) (*model.Metadata, error)
 	if err != nil {
 		return nil, err
 	}
+	_, err = parseSchemaVersion(meta.Schema)
+	if err != nil {
+		return nil, err
+	}
 	err = loadName(meta)
 	if err != nil {
 		return nil, err
@@ -107,7 +124,7 @@ func LoadMetadataFromOriginalSchema(schemaPath string) (*model.Metadata, error)
 	if err != nil {
 		return nil, err
 	}
-	err = loadOriginalSchemaVariables(meta, schemaPath)
+	err = loadOriginalSchemaVariables(meta, schemaPath, newLoadOptions(options))
 	if err != nil {
 		return nil, err
 	}
@@ -403,48 +420,44 @@ func LoadSummaryMachine(m *model.Metadata, summaryFile string) error {
 	return nil
 }
 
-func numLines(r io.Reader) (int, error) {
-	buf := make([]byte, 32*1024)
-	count := 0
-	lineSep := []byte{'\n'}
-
-	for {
-		c, err := r.Read(buf)
-		count += bytes.Count(buf[:c], lineSep)
-
-		switch {
-		case err == io.EOF:
-			return count, nil
-
-		case err != nil:
-			return count, err
-		}
-	}
+// DatasetStats describes the contents of a dataset file.
+type DatasetStats struct {
+	NumRows          int64  `json:"numRows"`
+	NumColumns       int64  `json:"numColumns"`
+	NumMalformedRows int64  `json:"numMalformedRows"`
+	Encoding         string `json:"encoding"`
 }
 
-// LoadDatasetStats loads the dataset and computes various stats.
-func LoadDatasetStats(m *model.Metadata, datasetPath string) error {
-
-	// open the left and outfiles for line-by-line by processing
-	f, err := os.Open(datasetPath)
-	if err != nil {
-		return errors.Wrap(err, "failed to open dataset file")
-	}
-
-	fi, err := f.Stat()
-	if err != nil {
-		return errors.Wrap(err, "failed to acquire stats on dataset file")
-	}
+// readEncoding determines the encoding of the input from its byte order
+// mark, skipping the UTF-8 one. Only UTF-8 input can be read as CSV.
+func readEncoding(input *bufio.Reader) string {
+	prefix, _ := input.Peek(len(utf8BOM))
+	switch {
+	case bytes.HasPrefix(prefix, utf8BOM):
+		input.Discard(len(utf8BOM))
+		return encodingUTF8BOM
+	case bytes.HasPrefix(prefix, utf16LEBOM):
+		return encodingUTF16LE
+	case bytes.HasPrefix(prefix, utf16BEBOM):
+		return encodingUTF16BE
+	}
+
+	return encodingUTF8
+}
 
-	m.NumBytes = fi.Size()
+// isReadableEncoding indicates whether or not input of the encoding can be
+// read as CSV.
+func isReadableEncoding(encoding string) bool {
+	return encoding != encodingUTF16LE && encoding != encodingUTF16BE
+}
 
-	lines, err := numLines(f)
-	if err != nil {
-		return errors.Wrap(err, "failed to count rows in file")
+func validUTF8(record []string) bool {
+	for _, field := range record {
+		if !utf8.ValidString(field) {
+			return false
+		}
 	}
-
-	m.NumRows = int64(lines)
-	return nil
+	return true
 }
 
 func loadID(m *model.Metadata) error {
@@ -501,7 +514,8 @@ func parseSchemaVariable(v *gabs.Container, existingVariables []*model.Variable,
 		varType = model.MapLLType(varType)
 	}
 
-	varIndex := 0
+	// columns without an index are indexed by their position.
+	varIndex := len(existingVariables)
 	if v.Path("colIndex").Data() != nil {
 		varIndex = int(v.Path("colIndex").Data().(float64))
 	}
@@ -550,8 +564,10 @@ func parseSchemaVariable(v *gabs.Container, existingVariables []*model.Variable,
 				}
 				refersTo["resObject"] = data
 			} else {
+				// the column names are strings and the column indices
+				// numbers, which are named once every resource is parsed.
 				for k, v := range resObjectMap {
-					resObject[k] = v.Data().(string)
+					resObject[k] = v.Data()
 				}
 				refersTo["resObject"] = resObject
 			}
@@ -571,6 +587,13 @@ func parseSchemaVariable(v *gabs.Container, existingVariables []*model.Variable,
 		refersTo,
 		existingVariables,
 		normalizeName)
+	variable.SelectedRole = selectedRole(varRoles)
+	if description, ok := v.Path("colDescription").Data().(string); ok {
+		variable.Description = description
+	}
+	if importance, ok := v.Path("importance").Data().(float64); ok {
+		variable.Importance = int(importance)
+	}
 	variable.SuggestedTypes = append(variable.SuggestedTypes, &model.SuggestedType{
 		Type:        variable.Type,
 		Probability: 2,
@@ -643,7 +666,7 @@ func parseSuggestedTypes(m *model.Metadata, name string, index int, labels []*ga
 	return suggested, nil
 }
 
-func loadOriginalSchemaVariables(m *model.Metadata, schemaPath string) error {
+func loadOriginalSchemaVariables(m *model.Metadata, schemaPath string, options *loadOptions) error {
 	dataResources, err := m.Schema.Path("dataResources").Children()
 	if err != nil {
 		return errors.Wrap(err, "failed to parse data resources")
@@ -653,37 +676,60 @@ func loadOriginalSchemaVariables(m *model.Metadata, schemaPath string) error {
 	m.DataResources = make([]*model.DataResource, len(dataResources))
 	for i, sv := range dataResources {
 		if sv.Path("resType").Data() == nil {
-			return fmt.Errorf("unable to parse resource type")
+			return fmt.Errorf("unable to parse resource type of dataResources[%d]", i)
 		}
 		resType := sv.Path("resType").Data().(string)
 
-		var parser DataResourceParser
-		switch resType {
-		case model.ResTypeAudio, model.ResTypeImage, model.ResTypeText:
-			parser = NewMedia(resType)
-			break
-		case model.ResTypeTable:
-			parser = &Table{}
-			break
-		case model.ResTypeTime:
-			parser = &Timeseries{}
-			break
-		case model.ResTypeRaw:
-			parser = &Raw{
-				rootPath: path.Dir(schemaPath),
-			}
-			break
-		default:
-			return errors.Errorf("Unrecognized resource type '%s'", resType)
+		parser, err := newDataResourceParser(resType, path.Dir(schemaPath), options)
+		if err != nil {
+			return err
 		}
 
 		dr, err := parser.Parse(sv)
 		if err != nil {
-			return errors.Wrapf(err, "Unable to parse data resource of type '%s'", resType)
+			return errors.Wrapf(err, "Unable to parse data resource dataResources[%d] of type '%s'", i, resType)
 		}
 
 		m.DataResources[i] = dr
 	}
+	return nameReferencedColumns(m)
+}
+
+// nameReferencedColumns sets the name of the columns referenced by their
+// index, as the references are followed by column name. References to a
+// missing resource are left as is.
+func nameReferencedColumns(m *model.Metadata) error {
+	resources := make(map[string]*model.DataResource)
+	for _, dr := range m.DataResources {
+		resources[dr.ResID] = dr
+	}
+
+	for _, dr := range m.DataResources {
+		for _, v := range dr.Variables {
+			resObject, ok := v.RefersTo["resObject"].(map[string]interface{})
+			if !ok || resObject["columnName"] != nil {
+				continue
+			}
+			index, ok := resObject["columnIndex"].(float64)
+			if !ok {
+				continue
+			}
+			resID, _ := v.RefersTo["resID"].(string)
+			referenced, ok := resources[resID]
+			if !ok {
+				continue
+			}
+			for _, rv := range referenced.Variables {
+				if float64(rv.Index) == index {
+					resObject["columnName"] = rv.Name
+				}
+			}
+			if resObject["columnName"] == nil {
+				return errors.Errorf("variable %s refers to column index %v missing from resource '%s'", v.Name, index, resID)
+			}
+		}
+	}
+
 	return nil
 }
 
@@ -791,22 +837,25 @@ func mergeVariables(m *model.Metadata, left []*gabs.Container, right []*gabs.Con
 }
 
 // WriteMergedSchema exports the current meta data as a merged schema file.
+// The fields of the original schema unknown to the metadata are kept.
 func WriteMergedSchema(m *model.Metadata, path string, mergedDataResource *model.DataResource) error {
-	// create output format
-	output := map[string]interface{}{
-		"about": map[string]interface{}{
-			"datasetID":            m.ID,
-			"datasetName":          m.Name,
-			"storageName":          m.StorageName,
-			"description":          m.Description,
-			"datasetSchemaVersion": schemaVersion,
-			"license":              license,
-			"rawData":              m.Raw,
-			"redacted":             m.Redacted,
-			"mergedSchema":         "true",
-		},
-		"dataResources": []*model.DataResource{mergedDataResource},
+	version := SchemaVersion(m)
+	dataResource, err := resourceDocument(mergedDataResource, version, nil, schemaColumns(m.Schema, ""))
+	if err != nil {
+		return err
 	}
+
+	// create output format, keeping the fields of the original schema
+	output := schemaDocument(m, map[string]interface{}{
+		"datasetID":            m.ID,
+		"datasetName":          m.Name,
+		"storageName":          m.StorageName,
+		"description":          m.Description,
+		"datasetSchemaVersion": version,
+		"rawData":              m.Raw,
+		"redacted":             m.Redacted,
+		"mergedSchema":         "true",
+	}, []interface{}{dataResource})
 	bytes, err := json.MarshalIndent(output, "", "    ")
 	if err != nil {
 		return errors.Wrap(err, "failed to marshal merged schema file output")
@@ -815,27 +864,41 @@ func WriteMergedSchema(m *model.Metadata, path string, mergedDataResource *model
 	return ioutil.WriteFile(path, bytes, 0644)
 }
 
-// WriteSchema exports the current meta data as a schema file.
+// WriteSchema exports the current meta data as a schema file, using the
+// version of the schema it was loaded from.
 func WriteSchema(m *model.Metadata, path string) error {
+	return WriteSchemaVersion(m, path, SchemaVersion(m))
+}
+
+// WriteSchemaVersion exports the current meta data as a schema file of the
+// target version, which keeps the version of the original schema when it has
+// the same major version. The fields of the original schema unknown to the
+// metadata, such as the license and qualities, are kept.
+func WriteSchemaVersion(m *model.Metadata, path string, version string) error {
+	version, err := targetSchemaVersion(m, version)
+	if err != nil {
+		return err
+	}
+
 	dataResources := make([]interface{}, 0)
 	for _, dr := range m.DataResources {
-		dataResources = append(dataResources, dr)
+		doc, err := resourceDocument(dr, version, schemaResource(m.Schema, dr.ResID), schemaColumns(m.Schema, dr.ResID))
+		if err != nil {
+			return err
+		}
+		dataResources = append(dataResources, doc)
 	}
 
-	output := map[string]interface{}{
-		"about": map[string]interface{}{
-			"datasetID":            m.ID,
-			"datasetName":          m.Name,
-			"storageName":          m.StorageName,
-			"description":          m.Description,
-			"datasetSchemaVersion": schemaVersion,
-			"license":              license,
-			"rawData":              m.Raw,
-			"redacted":             m.Redacted,
-			"mergedSchema":         "false",
-		},
-		"dataResources": dataResources,
-	}
+	output := schemaDocument(m, map[string]interface{}{
+		"datasetID":            m.ID,
+		"datasetName":          m.Name,
+		"storageName":          m.StorageName,
+		"description":          m.Description,
+		"datasetSchemaVersion": version,
+		"rawData":              m.Raw,
+		"redacted":             m.Redacted,
+		"mergedSchema":         "false",
+	}, dataResources)
 
 	bytes, err := json.MarshalIndent(output, "", "    ")
 	if err != nil {
@@ -846,30 +909,48 @@ func WriteSchema(m *model.Metadata, path string) error {
 }
 
 // IngestMetadata adds a document consisting of the metadata to the
-// provided index.
-func IngestMetadata(client *elastic.Client, index string, datasetPrefix string, datasetSource DatasetSource, meta *model.Metadata) error {
+// provided index. The statistics of the profile, which can be nil, are
+// stored with the variables.
+func IngestMetadata(cluster es.Cluster, index string, datasetPrefix string, datasetSource DatasetSource, meta *model.Metadata, profile *DatasetProfile) error {
 	// filter variables for surce object
 	if len(meta.DataResources) > 1 {
 		return errors.New("metadata variables not merged into a single dataset")
 	}
 
-	// clear refers to
-	for _, v := range meta.DataResources[0].Variables {
-		v.RefersTo = nil
+	// clear refers to, leaving the metadata untouched for the data ingest
+	variables := make([]*variableDocument, len(meta.DataResources[0].Variables))
+	for i, v := range meta.DataResources[0].Variables {
+		variable := *v
+		variable.RefersTo = nil
+		variables[i] = &variableDocument{
+			Variable:  &variable,
+			TypedName: typedName(v.Type, v.Name),
+		}
+		if profile != nil {
+			variables[i].Stats = profile.Variables[v.Name]
+		}
+	}
+
+	stats := &DatasetStats{}
+	if profile != nil && profile.Dataset != nil {
+		stats = profile.Dataset
 	}
 
 	source := map[string]interface{}{
-		"datasetName":    meta.Name,
-		"datasetID":      meta.ID,
-		"storageName":    meta.StorageName,
-		"description":    meta.Description,
-		"summary":        meta.Summary,
-		"summaryMachine": meta.SummaryMachine,
-		"numRows":        meta.NumRows,
-		"numBytes":       meta.NumBytes,
-		"variables":      meta.DataResources[0].Variables,
-		"datasetFolder":  meta.DatasetFolder,
-		"source":         datasetSource,
+		"datasetName":      meta.Name,
+		"datasetID":        meta.ID,
+		"storageName":      meta.StorageName,
+		"description":      meta.Description,
+		"summary":          meta.Summary,
+		"summaryMachine":   meta.SummaryMachine,
+		"numRows":          meta.NumRows,
+		"numBytes":         meta.NumBytes,
+		"numColumns":       stats.NumColumns,
+		"numMalformedRows": stats.NumMalformedRows,
+		"encoding":         stats.Encoding,
+		"variables":        variables,
+		"datasetFolder":    meta.DatasetFolder,
+		"source":           datasetSource,
 	}
 
 	bytes, err := json.Marshal(source)
@@ -878,16 +959,7 @@ func IngestMetadata(client *elastic.Client, index string, datasetPrefix string,
 	}
 
 	// push the document into the metadata index
-	_, err = client.Index().
-		Index(index).
-		Type("metadata").
-		Id(meta.ID).
-		BodyString(string(bytes)).
-		Do(context.Background())
-	if err != nil {
-		return errors.Wrapf(err, "failed to add document to index `%s`", index)
-	}
-	return nil
+	return cluster.IndexDocument(index, metadataDocType, meta.ID, string(bytes))
 }
 
 // DatasetMatches determines if the metadata variables match.
@@ -916,10 +988,21 @@ func DatasetMatches(m *model.Metadata, variables []string) bool {
 	return true
 }
 
-// CreateMetadataIndex creates a new ElasticSearch index with our target
-// mappings. An ngram analyze is defined and applied to the variable names to
-// allow for substring searching.
-func CreateMetadataIndex(client *elastic.Client, index string, overwrite bool) error {
+// CreateMetadataIndex creates a new ElasticSearch index with the settings
+// and mappings of the template, which defaults to an ngram analyzer applied
+// to the variable names to allow for substring searching. The template must
+// map every field of the metadata documents.
+func CreateMetadataIndex(cluster es.Cluster, index string, template *IndexTemplate, overwrite bool) error {
+	if template == nil {
+		template = DefaultIndexTemplate(cluster)
+	}
+	err := template.Validate()
+	if err != nil {
+		return err
+	}
+
+	client := cluster.Client()
+
 	// check if it already exists
 	exists, err := client.IndexExists(index).Do(context.Background())
 	if err != nil {
@@ -941,131 +1024,6 @@ func CreateMetadataIndex(client *elastic.Client, index string, overwrite bool) e
 		}
 	}
 
-	// create body
-	body := `{
-        "settings": {
-            "analysis": {
-                "filter": {
-                    "ngram_filter": {
-                        "type": "ngram",
-                        "min_gram": 4,
-                        "max_gram": 20
-                    },
-                    "search_filter": {
-                        "type": "edge_ngram",
-                        "min_gram": 1,
-                        "max_gram": 20
-                    }
-                },
-                "analyzer": {
-                    "ngram_analyzer": {
-                        "type": "custom",
-                        "tokenizer": "standard",
-                        "filter": [
-                            "lowercase",
-                            "ngram_filter"
-                        ]
-                    },
-                    "search_analyzer": {
-                        "type": "custom",
-                        "tokenizer": "standard",
-                        "filter": [
-                            "lowercase",
-                            "search_filter"
-                        ]
-                    }
-                }
-            }
-        },
-        "mappings": {
-            "metadata": {
-                "properties": {
-                    "datasetId": {
-                        "type": "text"
-                    },
-                    "datasetName": {
-                        "type": "text",
-                        "analyzer": "search_analyzer",
-                        "fields": {
-                            "keyword": {
-                                "type": "keyword",
-                                "ignore_above": 256
-                            }
-                        }
-                    },
-                    "storageName": {
-                        "type": "text"
-                    },
-                    "datasetFolder": {
-                        "type": "text"
-                    },
-                    "description": {
-                        "type": "text",
-                        "analyzer": "search_analyzer"
-                    },
-                    "summary": {
-                        "type": "text",
-                        "analyzer": "search_analyzer"
-                    },
-                    "summaryMachine": {
-                        "type": "text",
-                        "analyzer": "search_analyzer"
-                    },
-                    "numRows": {
-                        "type": "long"
-                    },
-                    "numBytes": {
-                        "type": "long"
-                    },
-                    "variables": {
-                        "properties": {
-                            "varDescription": {
-                                "type": "text"
-                            },
-                            "varName": {
-                                "type": "text",
-                                "analyzer": "search_analyzer",
-                                "include_in_all": true,
-                                "term_vector": "yes"
-                            },
-                            "colName": {
-                                "type": "text",
-                                "analyzer": "search_analyzer",
-                                "include_in_all": true,
-                                "term_vector": "yes"
-                            },
-                            "varRole": {
-                                "type": "text"
-                            },
-                            "varType": {
-                                "type": "text"
-                            },
-                            "varOriginalType": {
-                                "type": "text"
-                            },
-                            "varOriginalName": {
-                                "type": "text"
-                            },
-                            "varDisplayName": {
-                                "type": "text"
-                            },
-                            "importance": {
-                                "type": "integer"
-                            }
-                        }
-                    }
-                }
-            }
-        }
-    }`
-
 	// create index
-	created, err := client.CreateIndex(index).BodyString(body).Do(context.Background())
-	if err != nil {
-		return errors.Wrapf(err, "failed to create index %s", index)
-	}
-	if !created.Acknowledged {
-		return fmt.Errorf("Failed to create new index %s", index)
-	}
-	return nil
+	return cluster.CreateIndex(index, template.Settings, template.mappings(cluster))
 }
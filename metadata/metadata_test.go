@@ -16,13 +16,21 @@
 package metadata
 
 import (
+	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path"
+	"strings"
 	"testing"
 
 	"github.com/jeffail/gabs"
 	"github.com/stretchr/testify/assert"
 	elastic "gopkg.in/olivere/elastic.v5"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/es"
 )
 
 func TestMetadataFromSchema(t *testing.T) {
@@ -54,11 +62,30 @@ func TestIngestMetadata(t *testing.T) {
 		assert.NoError(t, err)
 		assert.Equal(t, reqBody.Path("datasetName").Data().(string), "test dataset")
 		assert.Equal(t, reqBody.Path("datasetID").Data().(string), "test_dataset")
+
+		// every field written needs to be in the index template
+		fields, err := reqBody.ChildrenMap()
+		assert.NoError(t, err)
+		for field := range fields {
+			assert.Contains(t, metadataFields, field)
+		}
+		for _, field := range metadataFields {
+			if !strings.Contains(field, ".") {
+				assert.True(t, reqBody.Exists(field), field)
+			}
+		}
 		assert.Equal(t, reqBody.Path("description").Data().(string), string("YOU ARE STANDING AT THE END OF A ROAD BEFORE A SMALL BRICK BUILDING."))
 
 		variables, err := reqBody.Path("variables").Children()
 		assert.NoError(t, err)
 		assert.Equal(t, 3, len(variables))
+		for _, variable := range variables {
+			fields, err := variable.ChildrenMap()
+			assert.NoError(t, err)
+			for field := range fields {
+				assert.Contains(t, metadataFields, "variables."+field)
+			}
+		}
 		assert.Equal(t, "bravo", variables[0].Path("colName").Data().(string))
 		roles := variables[0].Path("role").Data().([]interface{})
 		assert.Equal(t, "index", roles[0].(string))
@@ -75,6 +102,7 @@ func TestIngestMetadata(t *testing.T) {
 		roles = variables[2].Path("role").Data().([]interface{})
 		assert.Equal(t, "suggestedTarget", roles[0].(string))
 		assert.Equal(t, "integer", variables[2].Path("colType").Data().(string))
+		assert.Equal(t, "integer:whiskey", variables[2].Path("colTypedName").Data().(string))
 		assert.Equal(t, "integer", variables[2].Path("colOriginalType").Data().(string))
 
 		_, err = w.Write([]byte(`{
@@ -98,6 +126,478 @@ func TestIngestMetadata(t *testing.T) {
 	meta, err := LoadMetadataFromOriginalSchema("./testdata/datasetDoc.json")
 	assert.NoError(t, err)
 
-	err = IngestMetadata(client, "test_index", "", Seed, meta)
+	cluster, err := es.NewCluster(client, "5.6.0")
+	assert.NoError(t, err)
+
+	err = IngestMetadata(cluster, "test_index", "", Seed, meta, nil)
+	assert.NoError(t, err)
+}
+
+func TestMetadataMappings(t *testing.T) {
+	legacy, err := es.NewCluster(nil, "5.6.0")
+	assert.NoError(t, err)
+
+	mappings, err := gabs.Consume(DefaultIndexTemplate(legacy).mappings(legacy))
+	assert.NoError(t, err)
+	assert.Equal(t, "long", mappings.Path("metadata.properties.numRows.type").Data().(string))
+	assert.Equal(t, true, mappings.Path("metadata.properties.variables.properties.colName.include_in_all").Data().(bool))
+	assert.Nil(t, mappings.Path("metadata.properties.variables.type").Data())
+	assert.Equal(t, "keyword", mappings.Path("metadata.properties.variables.properties.colTypedName.type").Data().(string))
+	assert.Equal(t, "keyword", mappings.Path("metadata.properties.variables.properties.colType.fields.keyword.type").Data().(string))
+
+	typeless, err := es.NewCluster(nil, "7.2.0")
+	assert.NoError(t, err)
+
+	mappings, err = gabs.Consume(DefaultIndexTemplate(typeless).mappings(typeless))
+	assert.NoError(t, err)
+	assert.False(t, mappings.Exists("metadata"))
+	assert.Equal(t, "long", mappings.Path("properties.numRows.type").Data().(string))
+	assert.False(t, mappings.ExistsP("properties.variables.properties.colName.include_in_all"))
+}
+
+func TestLoadIndexTemplate(t *testing.T) {
+	template, err := LoadIndexTemplate("./testdata/index_template.json")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.0, template.Settings["number_of_shards"])
+
+	err = template.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "summaryMachine")
+
+	typeless, err := es.NewCluster(nil, "7.2.0")
+	assert.NoError(t, err)
+	assert.NoError(t, DefaultIndexTemplate(typeless).Validate())
+
+	// the fields of the variables are validated too.
+	template = DefaultIndexTemplate(typeless)
+	properties, err := gabs.Consume(template.Properties)
+	assert.NoError(t, err)
+	err = properties.Delete("variables", "properties", "colType")
+	assert.NoError(t, err)
+	err = properties.Delete("variables", "properties", "stats", "properties", "topValues")
+	assert.NoError(t, err)
+	err = template.Validate()
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), "variables.colType")
+	assert.Contains(t, err.Error(), "variables.stats.topValues")
+	assert.NotContains(t, err.Error(), "variables.stats.topValues.value")
+}
+
+func TestProfileDataset(t *testing.T) {
+	meta := &model.Metadata{
+		DataResources: []*model.DataResource{{
+			Variables: []*model.Variable{
+				{Name: "d3mIndex", Type: "index"},
+				{Name: "size", Type: "float"},
+				{Name: "color", Type: "categorical"},
+				{Name: "notes", Type: "text"},
+			},
+		}},
+	}
+
+	profile, err := ProfileDataset(meta, "./testdata/profile.csv", 1)
+	assert.NoError(t, err)
+
+	size := profile.Variables["size"]
+	assert.Equal(t, int64(4), size.Count)
+	assert.Equal(t, int64(1), size.NullCount)
+	assert.Equal(t, int64(3), size.DistinctCount)
+	assert.Equal(t, 1.5, *size.Min)
+	assert.Equal(t, 4.0, *size.Max)
+	assert.InDelta(t, 2.6667, *size.Mean, 0.0001)
+	assert.Nil(t, size.TopValues)
+
+	color := profile.Variables["color"]
+	assert.Equal(t, int64(2), color.DistinctCount)
+	assert.Equal(t, "red", color.TopValues[0].Value)
+	assert.Equal(t, int64(3), color.TopValues[0].Count)
+
+	notes := profile.Variables["notes"]
+	assert.Equal(t, int64(1), notes.NullCount)
+	assert.Equal(t, 2, *notes.MinLength)
+	assert.Equal(t, 11, *notes.MaxLength)
+	assert.Equal(t, int64(3), notes.DistinctCount)
+	assert.Nil(t, notes.TopValues)
+}
+
+func TestProfileDatasetStats(t *testing.T) {
+	meta := &model.Metadata{
+		DataResources: []*model.DataResource{{
+			Variables: []*model.Variable{
+				{Name: "d3mIndex", Type: "index"},
+				{Name: "name", Type: "categorical"},
+				{Name: "notes", Type: "text"},
+			},
+		}},
+	}
+
+	profile, err := ProfileDataset(meta, "./testdata/stats.csv", 1)
+	assert.NoError(t, err)
+	stats := profile.Dataset
+	assert.Equal(t, int64(4), stats.NumRows)
+	assert.Equal(t, int64(3), stats.NumColumns)
+	assert.Equal(t, int64(1), stats.NumMalformedRows)
+	assert.Equal(t, "utf-8-bom", stats.Encoding)
+	assert.Equal(t, int64(4), meta.NumRows)
+	assert.True(t, meta.NumBytes > 0)
+
+	// the malformed row is not profiled.
+	assert.Equal(t, int64(3), profile.Variables["name"].Count)
+	assert.Equal(t, 4, *profile.Variables["notes"].MinLength)
+
+	// UTF-16 files are reported rather than failing.
+	profile, err = ProfileDataset(meta, "./testdata/stats_utf16.csv", 1)
+	assert.NoError(t, err)
+	assert.Equal(t, "utf-16le", profile.Dataset.Encoding)
+	assert.Equal(t, int64(0), profile.Dataset.NumRows)
+	assert.Equal(t, int64(0), meta.NumRows)
+}
+
+func TestLoadDatasetStats(t *testing.T) {
+	meta := &model.Metadata{
+		DataResources: []*model.DataResource{{
+			Variables: []*model.Variable{
+				{Name: "d3mIndex", Type: "index"},
+				{Name: "name", Type: "categorical"},
+				{Name: "notes", Type: "text"},
+			},
+		}},
+	}
+
+	err := LoadDatasetStats(meta, "./testdata/stats.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(4), meta.NumRows)
+	assert.True(t, meta.NumBytes > 0)
+
+	meta.SchemaSource = model.SchemaSourceRaw
+	err = LoadDatasetStats(meta, "./testdata/stats.csv")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), meta.NumRows)
+
+	err = LoadDatasetStats(meta, "./testdata/missing.csv")
+	assert.Error(t, err)
+}
+
+func TestSearchMetadata(t *testing.T) {
+	testServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/test_index/_search", r.URL.Path)
+		reqBody, err := gabs.ParseJSONBuffer(r.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, "search_analyzer", reqBody.Path("query.bool.must.multi_match.analyzer").Data().(string))
+		assert.Equal(t, "baseball", reqBody.Path("query.bool.must.multi_match.query").Data().(string))
+
+		filters, err := reqBody.Path("query.bool.filter").Children()
+		assert.NoError(t, err)
+		assert.Equal(t, 3, len(filters))
+		assert.Equal(t, "text:alpha", filters[0].Search("term", "variables.colTypedName").Data().(string))
+		assert.Equal(t, "seed", filters[1].Path("match.source.query").Data().(string))
+		assert.Equal(t, float64(100), filters[2].Path("range.numRows.from").Data().(float64))
+
+		_, err = w.Write([]byte(`{
+				"took": 1,
+				"hits": {
+					"total": 1,
+					"max_score": 1.5,
+					"hits": [{
+						"_index": "test_index",
+						"_type": "metadata",
+						"_id": "test_dataset",
+						"_score": 1.5,
+						"_source": {
+							"datasetID": "test_dataset",
+							"datasetName": "test dataset",
+							"source": "seed",
+							"numRows": 120,
+							"variables": [{"colName": "alpha", "colType": "text"}]
+						}
+					}]
+				}
+			}`))
+		assert.NoError(t, err)
+	}))
+
+	client, err := elastic.NewSimpleClient(elastic.SetURL(testServer.URL))
+	assert.NoError(t, err)
+
+	cluster, err := es.NewCluster(client, "5.6.0")
+	assert.NoError(t, err)
+
+	query := &SearchQuery{
+		Text:         "baseball",
+		VariableName: "alpha",
+		VariableType: "text",
+		Source:       Seed,
+		MinRows:      100,
+		MaxRows:      -1,
+	}
+	results, err := SearchMetadata(cluster, "test_index", query, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, "test_dataset", results[0].ID)
+	assert.Equal(t, Seed, results[0].Source)
+	assert.Equal(t, int64(120), results[0].NumRows)
+	assert.Equal(t, "alpha", results[0].Variables[0].Name)
+	assert.Equal(t, 1.5, results[0].Score)
+}
+
+func TestValidateSchema(t *testing.T) {
+	problems, err := ValidateSchema("./testdata/validate/datasetDoc.json")
+	assert.NoError(t, err)
+	assert.True(t, HasErrors(problems))
+
+	found := make(map[string]Severity)
+	for _, p := range problems {
+		found[p.Path] = p.Severity
+	}
+	assert.Equal(t, map[string]Severity{
+		"dataResources[0].columns[1].refersTo.resObject.columnName":  SeverityError,
+		"dataResources[0].columns[2].colIndex":                       SeverityError,
+		"dataResources[0].columns[2].refersTo.resID":                 SeverityError,
+		"dataResources[0].columns[3].colType":                        SeverityWarning,
+		"dataResources[0].columns[3].colName":                        SeverityError,
+		"dataResources[0].columns[3].role[1]":                        SeverityWarning,
+		"dataResources[1].resPath":                                   SeverityError,
+		"dataResources[1].columns[2].refersTo.resObject.columnIndex": SeverityError,
+		"qualities[0].restrictedTo.resID":                            SeverityError,
+	}, found)
+
+	// the merged data file has no header.
+	problems, err = ValidateSchema("./testdata/validate/mergedDataSchema.json")
+	assert.NoError(t, err)
+	assert.Empty(t, problems)
+
+	// the data files of the test schema are not checked in.
+	meta, err := LoadMetadataFromOriginalSchema("./testdata/datasetDoc.json")
+	assert.NoError(t, err)
+	assert.False(t, HasErrors(Validate(meta, "")))
+}
+
+func TestLoadColumnIndexReferences(t *testing.T) {
+	meta, err := LoadMetadataFromOriginalSchema("./testdata/references/datasetDoc.json")
+	assert.NoError(t, err)
+	resObject := meta.DataResources[1].Variables[1].RefersTo["resObject"].(map[string]interface{})
+	assert.Equal(t, "label", resObject["columnName"])
+	assert.Equal(t, float64(1), resObject["columnIndex"])
+
+	// the data files of the test schema are not checked in.
+	assert.Empty(t, Validate(meta, ""))
+}
+
+func TestSchemaVersions(t *testing.T) {
+	meta, err := LoadMetadataFromOriginalSchema("./testdata/datasetDoc_v4.json")
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaVersion4, SchemaVersion(meta))
+
+	assert.Equal(t, "video", meta.DataResources[0].ResType)
+	assert.True(t, meta.DataResources[0].IsCollection)
+	assert.Equal(t, []string{"text/csv"}, meta.DataResources[1].ResFormat)
+	variables := meta.DataResources[1].Variables
+	assert.Equal(t, 3, len(variables))
+	assert.Equal(t, 2, variables[2].Index)
+	assert.Equal(t, model.VarRoleIndex, variables[0].SelectedRole)
+
+	assert.NoError(t, CheckSchemaVersion(SchemaVersion3))
+	assert.Error(t, CheckSchemaVersion("5.0.0"))
+
+	dir, err := ioutil.TempDir("", "schema")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// the original version keeps the extensions of the formats
+	err = WriteSchema(meta, path.Join(dir, "v4.json"))
+	assert.NoError(t, err)
+	written, err := gabs.ParseJSONFile(path.Join(dir, "v4.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaVersion4, written.Path("about.datasetSchemaVersion").Data().(string))
+	resources, err := written.Path("dataResources").Children()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"csv", "tsv"}, resources[1].Path("resFormat").Search("text/csv").Data())
+	assert.Equal(t, []interface{}{"multiIndex"}, resources[1].Path("columns").Index(0).Path("role").Data())
+	assert.NotNil(t, written.Path("qualities").Data())
+
+	err = WriteSchemaVersion(meta, path.Join(dir, "v3.json"), SchemaVersion3)
+	assert.NoError(t, err)
+	written, err = gabs.ParseJSONFile(path.Join(dir, "v3.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, SchemaVersion3, written.Path("about.datasetSchemaVersion").Data().(string))
+	resources, err = written.Path("dataResources").Children()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"text/csv"}, resources[1].Path("resFormat").Data())
+	assert.Equal(t, []interface{}{"index"}, resources[1].Path("columns").Index(0).Path("role").Data())
+
+	err = WriteSchemaVersion(meta, path.Join(dir, "v5.json"), "5.0.0")
+	assert.Error(t, err)
+
+	// a 3.x source keeps its version when written as 3.x
+	meta, err = LoadMetadataFromOriginalSchema("./testdata/datasetDoc.json")
+	assert.NoError(t, err)
+	err = WriteSchemaVersion(meta, path.Join(dir, "v3_1.json"), SchemaVersion3)
+	assert.NoError(t, err)
+	written, err = gabs.ParseJSONFile(path.Join(dir, "v3_1.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, schemaVersion, written.Path("about.datasetSchemaVersion").Data().(string))
+}
+
+func TestGraphResource(t *testing.T) {
+	meta, err := LoadMetadataFromOriginalSchema("./testdata/graph/datasetDoc.json")
+	assert.NoError(t, err)
+
+	graph := meta.DataResources[0]
+	assert.Equal(t, "graph", graph.ResType)
+	names := make([]string, len(graph.Variables))
+	for i, v := range graph.Variables {
+		names[i] = v.Name
+	}
+	assert.Equal(t, []string{"id", "label", "nodeID", "edge_source", "edge_target"}, names)
+	assert.Equal(t, "index", graph.Variables[2].Role[0])
+	assert.Equal(t, "text", graph.Variables[1].Type)
+	assert.Equal(t, "integer", graph.Variables[2].Type)
+}
+
+type customParser struct {
+	resType string
+}
+
+func (p *customParser) Parse(res *gabs.Container) (*model.DataResource, error) {
+	return &model.DataResource{
+		ResID:   res.Path("resID").Data().(string),
+		ResType: p.resType,
+	}, nil
+}
+
+func TestDataResourceParserRegistry(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schema")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	schemaPath := path.Join(dir, "datasetDoc.json")
+	err = ioutil.WriteFile(schemaPath, []byte(`{
+		"about": {"datasetID": "custom_dataset"},
+		"dataResources": [
+			{"resID": "0", "resPath": "custom/", "resType": "customType"},
+			{"resID": "1", "resPath": "other/", "resType": "otherType"}
+		]
+	}`), 0644)
+	assert.NoError(t, err)
+
+	RegisterDataResourceParser("customType", func(resType string, rootPath string) DataResourceParser {
+		return &customParser{
+			resType: resType,
+		}
+	})
+	defer UnregisterDataResourceParser("customType")
+
+	_, err = LoadMetadataFromOriginalSchema(schemaPath)
+	assert.Error(t, err)
+
+	meta, err := LoadMetadataFromOriginalSchema(schemaPath, CollectUnknownResources(true))
+	assert.NoError(t, err)
+	assert.Equal(t, "customType", meta.DataResources[0].ResType)
+	assert.Equal(t, "otherType", meta.DataResources[1].ResType)
+	assert.True(t, meta.DataResources[1].IsCollection)
+}
+
+func TestSchemaRoundTrip(t *testing.T) {
+	original, err := LoadMetadataFromOriginalSchema("./testdata/roundtrip/datasetDoc.json")
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "schema")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	err = WriteSchema(original, path.Join(dir, "datasetDoc.json"))
+	assert.NoError(t, err)
+	written, err := LoadMetadataFromOriginalSchema(path.Join(dir, "datasetDoc.json"))
+	assert.NoError(t, err)
+
+	// the metadata is unchanged
+	assert.Equal(t, original.ID, written.ID)
+	assert.Equal(t, original.Name, written.Name)
+	assert.Equal(t, original.StorageName, written.StorageName)
+	assert.Equal(t, original.Description, written.Description)
+	assert.Equal(t, original.Redacted, written.Redacted)
+	assert.Equal(t, original.DataResources, written.DataResources)
+
+	// every field of the original schema is kept
+	expected, err := gabs.ParseJSONFile("./testdata/roundtrip/datasetDoc.json")
+	assert.NoError(t, err)
+	assertContains(t, "", expected.Data(), written.Schema.Data())
+
+	// a second write is identical to the first
+	err = WriteSchema(written, path.Join(dir, "datasetDoc2.json"))
+	assert.NoError(t, err)
+	first, err := ioutil.ReadFile(path.Join(dir, "datasetDoc.json"))
+	assert.NoError(t, err)
+	second, err := ioutil.ReadFile(path.Join(dir, "datasetDoc2.json"))
+	assert.NoError(t, err)
+	assert.Equal(t, string(first), string(second))
+}
+
+func TestMergedSchemaRoundTrip(t *testing.T) {
+	original, err := LoadMetadataFromOriginalSchema("./testdata/roundtrip/datasetDoc.json")
+	assert.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "schema")
+	assert.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	// a variable derived from an original column does not take its fields
+	merged := *original.DataResources[0]
+	derived := *merged.Variables[1]
+	derived.Name = "city_region"
+	derived.OriginalVariable = "city"
+	derived.Index = len(merged.Variables)
+	merged.Variables = append(append([]*model.Variable{}, merged.Variables...), &derived)
+	merged.ResPath = "tables/merged.csv"
+
+	err = WriteMergedSchema(original, path.Join(dir, "mergedDataSchema.json"), &merged)
+	assert.NoError(t, err)
+	written, err := LoadMetadataFromMergedSchema(path.Join(dir, "mergedDataSchema.json"))
 	assert.NoError(t, err)
+
+	assert.Equal(t, original.ID, written.ID)
+	assert.Equal(t, original.Name, written.Name)
+	assert.Equal(t, SchemaVersion4, SchemaVersion(written))
+	assert.Equal(t, "true", written.Schema.Path("about.mergedSchema").Data())
+	assert.Equal(t, len(merged.Variables), len(written.DataResources[0].Variables))
+	for i, v := range written.DataResources[0].Variables {
+		assert.Equal(t, merged.Variables[i].Name, v.Name)
+		assert.Equal(t, merged.Variables[i].Index, v.Index)
+	}
+
+	// the fields of the original schema are kept
+	assert.NotNil(t, written.Schema.Path("qualities").Data())
+	assert.NotNil(t, written.Schema.Path("customKey").Data())
+	resources, err := written.Schema.Path("dataResources").Children()
+	assert.NoError(t, err)
+	assert.Equal(t, []interface{}{"csv"}, resources[0].Path("resFormat").Search("text/csv").Data())
+	columns, err := resources[0].Path("columns").Children()
+	assert.NoError(t, err)
+	assert.NotNil(t, columns[1].Path("timeGranularity").Data())
+	assert.Nil(t, columns[3].Path("timeGranularity").Data())
+}
+
+// assertContains checks that every value of the expected document is in the
+// actual document.
+func assertContains(t *testing.T, path string, expected interface{}, actual interface{}) {
+	switch e := expected.(type) {
+	case map[string]interface{}:
+		a, ok := actual.(map[string]interface{})
+		if !assert.True(t, ok, path) {
+			return
+		}
+		for k, v := range e {
+			assertContains(t, path+"."+k, v, a[k])
+		}
+	case []interface{}:
+		a, ok := actual.([]interface{})
+		if !assert.True(t, ok, path) || !assert.Equal(t, len(e), len(a), path) {
+			return
+		}
+		for i, v := range e {
+			assertContains(t, fmt.Sprintf("%s[%d]", path, i), v, a[i])
+		}
+	default:
+		assert.Equal(t, expected, actual, path)
+	}
 }
@@ -0,0 +1,384 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metadata
+
+import (
+	"bufio"
+	"encoding/csv"
+	"hash/fnv"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	log "github.com/unchartedsoftware/plog"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+const (
+	// topValueCount is the number of most frequent values kept for the
+	// categorical variables.
+	topValueCount = 10
+	// maxDistinctValues caps the number of distinct values, or hashes of the
+	// values, tracked for a variable, past which the distinct count is a
+	// lower bound.
+	maxDistinctValues = 100000
+)
+
+var (
+	numericProfileTypes = map[string]bool{
+		"index":     true,
+		"integer":   true,
+		"float":     true,
+		"real":      true,
+		"latitude":  true,
+		"longitude": true,
+	}
+	textProfileTypes = map[string]bool{
+		"text": true,
+	}
+	categoricalProfileTypes = map[string]bool{
+		"categorical": true,
+		"boolean":     true,
+	}
+)
+
+// DatasetProfile holds the statistics of a dataset and of its variables.
+type DatasetProfile struct {
+	Dataset   *DatasetStats
+	Variables map[string]*VariableStats
+}
+
+// variableDocument is a variable along with its statistics, as stored in
+// the metadata document. The typed name allows filtering the variables on
+// both their name and type, which the variables of a dataset being a plain
+// object cannot do otherwise.
+type variableDocument struct {
+	*model.Variable
+	TypedName string         `json:"colTypedName"`
+	Stats     *VariableStats `json:"stats,omitempty"`
+}
+
+// VariableStats summarizes the values of a variable. Numeric statistics are
+// only set for numeric variables, top values for categorical variables and
+// length statistics for text variables.
+type VariableStats struct {
+	Count          int64         `json:"count"`
+	NullCount      int64         `json:"nullCount"`
+	DistinctCount  int64         `json:"distinctCount"`
+	DistinctCapped bool          `json:"distinctCapped,omitempty"`
+	Min            *float64      `json:"min,omitempty"`
+	Max            *float64      `json:"max,omitempty"`
+	Mean           *float64      `json:"mean,omitempty"`
+	StdDev         *float64      `json:"stddev,omitempty"`
+	TopValues      []*ValueCount `json:"topValues,omitempty"`
+	MinLength      *int          `json:"minLength,omitempty"`
+	MaxLength      *int          `json:"maxLength,omitempty"`
+	MeanLength     *float64      `json:"meanLength,omitempty"`
+}
+
+// ValueCount is the number of occurrences of a value.
+type ValueCount struct {
+	Value string `json:"value"`
+	Count int64  `json:"count"`
+}
+
+// DatasetProfiler accumulates the statistics of the variables of a dataset
+// over a single pass of its rows.
+type DatasetProfiler struct {
+	variables []*model.Variable
+	profilers []*variableProfiler
+	rows      int64
+}
+
+// variableProfiler accumulates the statistics of a variable over a single
+// pass of the data. Only the values of the categorical variables are kept,
+// to find the most frequent ones, the other values being hashed to count
+// them.
+type variableProfiler struct {
+	typ      string
+	count    int64
+	nulls    int64
+	values   map[string]int64
+	hashes   map[uint64]bool
+	capped   bool
+	numbers  int64
+	min      float64
+	max      float64
+	mean     float64
+	m2       float64
+	lengths  int64
+	minLen   int
+	maxLen   int
+	totalLen int64
+}
+
+// HeaderRowCount returns the number of leading rows of the dataset file which
+// do not hold data.
+func HeaderRowCount(m *model.Metadata) int {
+	// Raw schema source will have an additional header row.
+	if m.SchemaSource == model.SchemaSourceRaw {
+		return 2
+	}
+	return 1
+}
+
+// LoadDatasetStats sets the row count and size of the dataset on the
+// metadata, reading the dataset as CSV with the same pass as ProfileDataset.
+func LoadDatasetStats(m *model.Metadata, datasetPath string) error {
+	_, err := ProfileDataset(m, datasetPath, HeaderRowCount(m))
+	return err
+}
+
+// ProfileDataset reads the dataset as CSV, skipping its header rows, to
+// compute the stats of the dataset and of every variable of the main data
+// resource in a single pass. Records spanning several lines are counted
+// once. Rows which cannot be parsed or do not have as many columns as the
+// first row are counted as malformed and are not profiled, nor are rows not
+// holding a value per variable. The row count and
+// size are set on the metadata. A file encoded in UTF-16 cannot be read, so
+// only its encoding is reported.
+func ProfileDataset(m *model.Metadata, datasetPath string, headerRows int) (*DatasetProfile, error) {
+	f, err := os.Open(datasetPath)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to open dataset file")
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to acquire stats on dataset file")
+	}
+	m.NumBytes = fi.Size()
+
+	variables := m.DataResources[0].Variables
+	profiler := NewDatasetProfiler(variables)
+
+	input := bufio.NewReader(f)
+	stats := &DatasetStats{
+		Encoding: readEncoding(input),
+	}
+	if !isReadableEncoding(stats.Encoding) {
+		log.Warnf("Dataset file %s is encoded in %s, which cannot be profiled", datasetPath, stats.Encoding)
+		m.NumRows = 0
+		profile := profiler.Profile()
+		profile.Dataset = stats
+		return profile, nil
+	}
+
+	reader := csv.NewReader(input)
+	reader.FieldsPerRecord = -1
+
+	recordCount := 0
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*csv.ParseError); err != nil && !ok {
+			return nil, errors.Wrap(err, "failed to read dataset file")
+		}
+
+		recordCount = recordCount + 1
+		if recordCount == 1 {
+			stats.NumColumns = int64(len(record))
+		}
+		if recordCount <= headerRows {
+			continue
+		}
+
+		stats.NumRows = stats.NumRows + 1
+		if err != nil || int64(len(record)) != stats.NumColumns {
+			stats.NumMalformedRows = stats.NumMalformedRows + 1
+			continue
+		}
+		if stats.Encoding == encodingUTF8 && !validUTF8(record) {
+			stats.Encoding = encodingUnknown
+		}
+
+		if len(record) == len(variables) {
+			profiler.Add(record)
+		}
+	}
+
+	m.NumRows = stats.NumRows
+	profile := profiler.Profile()
+	profile.Dataset = stats
+
+	return profile, nil
+}
+
+// NewDatasetProfiler creates a profiler of the variables.
+func NewDatasetProfiler(variables []*model.Variable) *DatasetProfiler {
+	profilers := make([]*variableProfiler, len(variables))
+	for i, v := range variables {
+		profilers[i] = &variableProfiler{
+			typ: v.Type,
+		}
+		if categoricalProfileTypes[v.Type] {
+			profilers[i].values = make(map[string]int64)
+		} else {
+			profilers[i].hashes = make(map[uint64]bool)
+		}
+	}
+
+	return &DatasetProfiler{
+		variables: variables,
+		profilers: profilers,
+	}
+}
+
+// Add profiles the values of a row, which holds a value per variable.
+func (p *DatasetProfiler) Add(record []string) {
+	p.rows = p.rows + 1
+	for i, value := range record {
+		p.profilers[i].add(value)
+	}
+}
+
+// RowCount returns the number of rows added so far.
+func (p *DatasetProfiler) RowCount() int64 {
+	return p.rows
+}
+
+// Profile returns the statistics of the variables over the rows added so
+// far.
+func (p *DatasetProfiler) Profile() *DatasetProfile {
+	profile := &DatasetProfile{
+		Variables: make(map[string]*VariableStats),
+	}
+	for i, v := range p.variables {
+		profile.Variables[v.Name] = p.profilers[i].stats()
+	}
+
+	return profile
+}
+
+func (p *variableProfiler) add(value string) {
+	p.count = p.count + 1
+
+	trimmed := strings.TrimSpace(value)
+	if trimmed == "" {
+		p.nulls = p.nulls + 1
+		return
+	}
+
+	if p.values != nil {
+		if _, ok := p.values[value]; ok || len(p.values) < maxDistinctValues {
+			p.values[value] = p.values[value] + 1
+		} else {
+			p.capped = true
+		}
+	} else {
+		h := fnv.New64a()
+		h.Write([]byte(value))
+		hash := h.Sum64()
+		if p.hashes[hash] || len(p.hashes) < maxDistinctValues {
+			p.hashes[hash] = true
+		} else {
+			p.capped = true
+		}
+	}
+
+	switch {
+	case numericProfileTypes[p.typ]:
+		number, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			return
+		}
+		// Welford's algorithm keeps the variance stable in a single pass.
+		p.numbers = p.numbers + 1
+		if p.numbers == 1 || number < p.min {
+			p.min = number
+		}
+		if p.numbers == 1 || number > p.max {
+			p.max = number
+		}
+		delta := number - p.mean
+		p.mean = p.mean + delta/float64(p.numbers)
+		p.m2 = p.m2 + delta*(number-p.mean)
+	case textProfileTypes[p.typ]:
+		length := utf8.RuneCountInString(value)
+		p.lengths = p.lengths + 1
+		if p.lengths == 1 || length < p.minLen {
+			p.minLen = length
+		}
+		if p.lengths == 1 || length > p.maxLen {
+			p.maxLen = length
+		}
+		p.totalLen = p.totalLen + int64(length)
+	}
+}
+
+func (p *variableProfiler) stats() *VariableStats {
+	stats := &VariableStats{
+		Count:          p.count,
+		NullCount:      p.nulls,
+		DistinctCount:  int64(len(p.values) + len(p.hashes)),
+		DistinctCapped: p.capped,
+	}
+
+	switch {
+	case numericProfileTypes[p.typ]:
+		if p.numbers > 0 {
+			min, max, mean := p.min, p.max, p.mean
+			stddev := math.Sqrt(p.m2 / float64(p.numbers))
+			stats.Min = &min
+			stats.Max = &max
+			stats.Mean = &mean
+			stats.StdDev = &stddev
+		}
+	case textProfileTypes[p.typ]:
+		if p.lengths > 0 {
+			minLen, maxLen := p.minLen, p.maxLen
+			meanLen := float64(p.totalLen) / float64(p.lengths)
+			stats.MinLength = &minLen
+			stats.MaxLength = &maxLen
+			stats.MeanLength = &meanLen
+		}
+	case categoricalProfileTypes[p.typ]:
+		stats.TopValues = topValues(p.values, topValueCount)
+	}
+
+	return stats
+}
+
+// topValues returns the most frequent values, ties being sorted by value.
+func topValues(values map[string]int64, count int) []*ValueCount {
+	top := make([]*ValueCount, 0, len(values))
+	for value, c := range values {
+		top = append(top, &ValueCount{
+			Value: value,
+			Count: c,
+		})
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Count != top[j].Count {
+			return top[i].Count > top[j].Count
+		}
+		return top[i].Value < top[j].Value
+	})
+	if len(top) > count {
+		top = top[:count]
+	}
+
+	return top
+}
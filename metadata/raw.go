@@ -24,6 +24,14 @@ import (
 	"github.com/uncharted-distil/distil-compute/model"
 )
 
+func init() {
+	RegisterDataResourceParser(model.ResTypeRaw, func(resType string, rootPath string) DataResourceParser {
+		return &Raw{
+			rootPath: rootPath,
+		}
+	})
+}
+
 // Raw is a data resource that is contained within one file which does not
 // have fields specified in the schema.
 type Raw struct {
@@ -42,18 +50,9 @@ func (r *Raw) Parse(res *gabs.Container) (*model.DataResource, error) {
 	}
 	resPath := res.Path("resPath").Data().(string)
 
-	var resFormats []string
-	if res.Path("resFormat").Data() != nil {
-		formatsRaw, err := res.Path("resFormat").Children()
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to parse resource format")
-		}
-		resFormats = make([]string, len(formatsRaw))
-		for i, r := range formatsRaw {
-			resFormats[i] = r.Data().(string)
-		}
-	} else {
-		resFormats = make([]string, 0)
+	resFormats, err := parseResourceFormats(res)
+	if err != nil {
+		return nil, err
 	}
 
 	dr, err := loadRawVariables(path.Join(r.rootPath, resPath))
@@ -16,12 +16,89 @@
 package metadata
 
 import (
+	"sync"
+
 	"github.com/jeffail/gabs"
+	"github.com/pkg/errors"
 
 	"github.com/uncharted-distil/distil-compute/model"
 )
 
+var (
+	parsers     = make(map[string]DataResourceParserConstructor)
+	parsersLock sync.RWMutex
+)
+
 // DataResourceParser is a parser for a data resource in the schema document.
 type DataResourceParser interface {
 	Parse(res *gabs.Container) (*model.DataResource, error)
 }
+
+// DataResourceParserConstructor creates the parser of a resource type. The
+// root path is the folder of the schema document, from which the resource
+// files are resolved.
+type DataResourceParserConstructor func(resType string, rootPath string) DataResourceParser
+
+// RegisterDataResourceParser registers the parser constructor of a resource
+// type, replacing the constructor registered for the type if any.
+func RegisterDataResourceParser(resType string, constructor DataResourceParserConstructor) {
+	parsersLock.Lock()
+	defer parsersLock.Unlock()
+	parsers[resType] = constructor
+}
+
+// UnregisterDataResourceParser removes the parser constructor registered
+// for a resource type.
+func UnregisterDataResourceParser(resType string) {
+	parsersLock.Lock()
+	defer parsersLock.Unlock()
+	delete(parsers, resType)
+}
+
+// LoadOption sets an option of the schema loaders.
+type LoadOption func(*loadOptions)
+
+type loadOptions struct {
+	collectUnknownResources bool
+}
+
+// CollectUnknownResources sets whether or not resources of an unknown type
+// are loaded as generic collections rather than failing the load.
+func CollectUnknownResources(collect bool) LoadOption {
+	return func(o *loadOptions) {
+		o.collectUnknownResources = collect
+	}
+}
+
+func newLoadOptions(options []LoadOption) *loadOptions {
+	o := &loadOptions{}
+	for _, option := range options {
+		option(o)
+	}
+	return o
+}
+
+// newDataResourceParser creates the parser registered for the resource type,
+// falling back to a generic collection when enabled.
+func newDataResourceParser(resType string, rootPath string, options *loadOptions) (DataResourceParser, error) {
+	parsersLock.RLock()
+	defer parsersLock.RUnlock()
+
+	constructor, ok := parsers[resType]
+	if ok {
+		return constructor(resType, rootPath), nil
+	}
+	if options.collectUnknownResources {
+		return NewMedia(resType), nil
+	}
+	return nil, errors.Errorf("Unrecognized resource type '%s'", resType)
+}
+
+// isRegisteredResourceType indicates whether or not a parser is registered
+// for the resource type.
+func isRegisteredResourceType(resType string) bool {
+	parsersLock.RLock()
+	defer parsersLock.RUnlock()
+	_, ok := parsers[resType]
+	return ok
+}
@@ -0,0 +1,162 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/pkg/errors"
+	elastic "gopkg.in/olivere/elastic.v5"
+
+	"github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/es"
+)
+
+const (
+	searchAnalyzer = "search_analyzer"
+
+	// typedNameScript sets the typed name of the variables of the datasets
+	// ingested before it was stored, when copying them to a new index.
+	typedNameScript = "if (ctx._source.variables != null) { for (def v : ctx._source.variables) { v.colTypedName = v.colType + ':' + v.colName; } }"
+)
+
+var (
+	// searchFields lists the fields matched against the search text, along
+	// with their boost.
+	searchFields = []string{
+		"datasetName^2",
+		"description",
+		"summary",
+		"summaryMachine",
+		"variables.colName",
+	}
+)
+
+// SearchQuery filters the datasets of the metadata index. Empty fields and
+// negative row bounds are not applied.
+type SearchQuery struct {
+	Text         string
+	VariableName string
+	VariableType string
+	Source       DatasetSource
+	MinRows      int64
+	MaxRows      int64
+}
+
+// SearchResult is a dataset matching a search query.
+type SearchResult struct {
+	ID          string            `json:"datasetID"`
+	Name        string            `json:"datasetName"`
+	Description string            `json:"description"`
+	Source      DatasetSource     `json:"source"`
+	NumRows     int64             `json:"numRows"`
+	NumBytes    int64             `json:"numBytes"`
+	Variables   []*model.Variable `json:"variables"`
+	Score       float64           `json:"score"`
+}
+
+// ParseDatasetSource parses the source of a dataset, as set on ingest.
+func ParseDatasetSource(source string) (DatasetSource, error) {
+	switch DatasetSource(source) {
+	case Seed, Contrib, Augmented:
+		return DatasetSource(source), nil
+	}
+	return "", errors.Errorf("unrecognized dataset source '%s'", source)
+}
+
+// SearchMetadata searches the metadata index for the datasets matching the
+// query. The text is analyzed with the search analyzer of the index so that
+// it matches partial words of the names and descriptions. At most size
+// datasets are returned, best matches first.
+func SearchMetadata(cluster es.Cluster, index string, query *SearchQuery, size int) ([]*SearchResult, error) {
+	hits, err := cluster.Search(index, buildSearchQuery(query), size)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*SearchResult, 0)
+	if hits == nil {
+		return results, nil
+	}
+	for _, hit := range hits.Hits {
+		if hit.Source == nil {
+			continue
+		}
+		result := &SearchResult{}
+		err = json.Unmarshal(*hit.Source, result)
+		if err != nil {
+			return nil, errors.Wrapf(err, "failed to parse dataset `%s`", hit.Id)
+		}
+		if hit.Score != nil {
+			result.Score = *hit.Score
+		}
+		results = append(results, result)
+	}
+
+	return results, nil
+}
+
+// TypedNameScript returns the script setting the typed name of the
+// variables of the metadata documents, to apply when copying the documents
+// of an index.
+func TypedNameScript() *elastic.Script {
+	return elastic.NewScript(typedNameScript).Lang("painless")
+}
+
+// typedName combines the type and the name of a variable. The types do not
+// hold a colon, so the combination is unambiguous.
+func typedName(typ string, name string) string {
+	return fmt.Sprintf("%s:%s", typ, name)
+}
+
+// buildSearchQuery matches the text against the dataset fields and the
+// variable names. The variable filters are exact, and apply to the same
+// variable when both are set.
+func buildSearchQuery(query *SearchQuery) elastic.Query {
+	q := elastic.NewBoolQuery()
+
+	if query.Text != "" {
+		q = q.Must(elastic.NewMultiMatchQuery(query.Text, searchFields...).
+			Analyzer(searchAnalyzer))
+	} else {
+		q = q.Must(elastic.NewMatchAllQuery())
+	}
+
+	switch {
+	case query.VariableName != "" && query.VariableType != "":
+		q = q.Filter(elastic.NewTermQuery("variables.colTypedName", typedName(query.VariableType, query.VariableName)))
+	case query.VariableName != "":
+		q = q.Filter(elastic.NewTermQuery("variables.colName.keyword", query.VariableName))
+	case query.VariableType != "":
+		q = q.Filter(elastic.NewTermQuery("variables.colType.keyword", query.VariableType))
+	}
+	if query.Source != "" {
+		q = q.Filter(elastic.NewMatchQuery("source", string(query.Source)))
+	}
+	if query.MinRows >= 0 || query.MaxRows >= 0 {
+		rows := elastic.NewRangeQuery("numRows")
+		if query.MinRows >= 0 {
+			rows = rows.Gte(query.MinRows)
+		}
+		if query.MaxRows >= 0 {
+			rows = rows.Lte(query.MaxRows)
+		}
+		q = q.Filter(rows)
+	}
+
+	return q
+}
@@ -0,0 +1,16 @@
+graph [
+  node [
+    id 0
+    nodeID 10
+    label "ten"
+  ]
+  node [
+    id 1
+    nodeID 11
+    label "eleven"
+  ]
+  edge [
+    source 0
+    target 1
+  ]
+]
@@ -24,6 +24,12 @@ import (
 	"github.com/uncharted-distil/distil-compute/model"
 )
 
+func init() {
+	RegisterDataResourceParser(model.ResTypeTime, func(resType string, rootPath string) DataResourceParser {
+		return &Timeseries{}
+	})
+}
+
 // Timeseries is a data resource that is contained within one or many timeseries files.
 type Timeseries struct {
 }
@@ -45,18 +51,9 @@ func (r *Timeseries) Parse(res *gabs.Container) (*model.DataResource, error) {
 	}
 	resPath := res.Path("resPath").Data().(string)
 
-	var resFormats []string
-	if res.Path("resFormat").Data() != nil {
-		formatsRaw, err := res.Path("resFormat").Children()
-		if err != nil {
-			return nil, errors.Wrap(err, "unable to parse resource format")
-		}
-		resFormats = make([]string, len(formatsRaw))
-		for i, r := range formatsRaw {
-			resFormats[i] = r.Data().(string)
-		}
-	} else {
-		resFormats = make([]string, 0)
+	resFormats, err := parseResourceFormats(res)
+	if err != nil {
+		return nil, err
 	}
 
 	dr := &model.DataResource{
@@ -68,10 +65,10 @@ func (r *Timeseries) Parse(res *gabs.Container) (*model.DataResource, error) {
 		Variables:    make([]*model.Variable, 0),
 	}
 
-	for _, v := range schemaVariables {
+	for i, v := range schemaVariables {
 		variable, err := parseSchemaVariable(v, dr.Variables, false)
 		if err != nil {
-			return nil, err
+			return nil, errors.Wrapf(err, "unable to parse columns[%d]", i)
 		}
 		dr.Variables = append(dr.Variables, variable)
 	}
@@ -0,0 +1,392 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metadata
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+
+	"github.com/jeffail/gabs"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+// Severity indicates whether a problem prevents the schema from being used.
+type Severity string
+
+const (
+	// SeverityError flags a problem which fails or corrupts the ingest.
+	SeverityError Severity = "error"
+	// SeverityWarning flags a problem which the ingest works around.
+	SeverityWarning Severity = "warning"
+)
+
+// Problem is an issue found in a schema document, located by its JSON path.
+type Problem struct {
+	Path     string   `json:"path"`
+	Severity Severity `json:"severity"`
+	Message  string   `json:"message"`
+}
+
+func (p *Problem) String() string {
+	return fmt.Sprintf("%s %s: %s", p.Severity, p.Path, p.Message)
+}
+
+// HasErrors indicates whether or not any of the problems is an error.
+func HasErrors(problems []*Problem) bool {
+	for _, p := range problems {
+		if p.Severity == SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// resourceColumns indexes the columns of a resource to resolve references.
+type resourceColumns struct {
+	names map[string]bool
+	count int
+}
+
+// declaredColumn is a column of a resource along with its location.
+type declaredColumn struct {
+	name string
+	path string
+}
+
+// validator collects the problems found while walking a schema.
+type validator struct {
+	rootPath string
+	merged   bool
+	options  *loadOptions
+	problems []*Problem
+}
+
+func (v *validator) errorf(path string, format string, args ...interface{}) {
+	v.add(path, SeverityError, format, args...)
+}
+
+func (v *validator) warnf(path string, format string, args ...interface{}) {
+	v.add(path, SeverityWarning, format, args...)
+}
+
+func (v *validator) add(path string, severity Severity, format string, args ...interface{}) {
+	v.problems = append(v.problems, &Problem{
+		Path:     path,
+		Severity: severity,
+		Message:  fmt.Sprintf(format, args...),
+	})
+}
+
+// ValidateSchema parses the schema document at the path and validates it
+// along with the files it references. An error is only returned when the
+// document cannot be parsed.
+func ValidateSchema(schemaPath string, options ...LoadOption) ([]*Problem, error) {
+	meta := &model.Metadata{}
+	err := loadSchema(meta, schemaPath)
+	if err != nil {
+		return nil, err
+	}
+
+	return Validate(meta, path.Dir(schemaPath), options...), nil
+}
+
+// Validate walks the schema document of the metadata and reports every
+// problem found, rather than stopping at the first one as the loaders do.
+// The files of the data resources are resolved from the root path, and the
+// declared columns are checked against the headers of the tables, except
+// for merged schemas whose data file has no header. The files are not
+// checked when the root path is empty. The load options decide which
+// resource types are loaded.
+func Validate(meta *model.Metadata, rootPath string, options ...LoadOption) []*Problem {
+	v := &validator{
+		rootPath: rootPath,
+		options:  newLoadOptions(options),
+		problems: make([]*Problem, 0),
+	}
+	if meta.Schema == nil {
+		v.errorf("", "missing schema document")
+		return v.problems
+	}
+	merged, _ := meta.Schema.Path("about.mergedSchema").Data().(string)
+	v.merged = merged == "true"
+
+	if _, err := parseSchemaVersion(meta.Schema); err != nil {
+		v.errorf("about.datasetSchemaVersion", "%v", err)
+	}
+	if _, ok := meta.Schema.Path("about.datasetID").Data().(string); !ok {
+		v.errorf("about.datasetID", "missing dataset id")
+	}
+	if _, ok := meta.Schema.Path("about.datasetName").Data().(string); !ok {
+		v.warnf("about.datasetName", "missing dataset name")
+	}
+
+	resources, err := meta.Schema.Path("dataResources").Children()
+	if err != nil {
+		v.errorf("dataResources", "missing data resources")
+		return v.problems
+	}
+
+	// index the columns by resource to resolve the references.
+	columns := make(map[string]*resourceColumns)
+	for _, res := range resources {
+		resID, ok := res.Path("resID").Data().(string)
+		if !ok {
+			continue
+		}
+		cols, _ := res.Path("columns").Children()
+		columns[resID] = &resourceColumns{
+			names: make(map[string]bool),
+			count: len(cols),
+		}
+		for _, col := range cols {
+			if name, ok := col.Path("colName").Data().(string); ok {
+				columns[resID].names[name] = true
+			}
+		}
+	}
+
+	resIDs := make(map[string]bool)
+	for i, res := range resources {
+		resPath := fmt.Sprintf("dataResources[%d]", i)
+		resID, ok := res.Path("resID").Data().(string)
+		if !ok {
+			v.errorf(resPath+".resID", "missing resource id")
+		} else if resIDs[resID] {
+			v.errorf(resPath+".resID", "duplicate resource id '%s'", resID)
+		}
+		resIDs[resID] = true
+
+		v.validateResource(res, resPath, columns)
+	}
+
+	v.validateQualities(meta.Schema, resIDs)
+
+	return v.problems
+}
+
+// validateQualities checks that the qualities parse and that the resources
+// they are restricted to exist.
+func (v *validator) validateQualities(schema *gabs.Container, resIDs map[string]bool) {
+	if schema.Path("qualities").Data() == nil {
+		return
+	}
+	qualities, err := schema.Path("qualities").Children()
+	if err != nil {
+		v.errorf("qualities", "qualities are not a list")
+		return
+	}
+	for i, q := range qualities {
+		qualPath := fmt.Sprintf("qualities[%d]", i)
+		quality, err := parseQuality(q)
+		if err != nil {
+			v.errorf(qualPath, "%v", err)
+			continue
+		}
+		if resID, ok := quality.RestrictedTo["resID"].(string); ok && !resIDs[resID] {
+			v.errorf(qualPath+".restrictedTo.resID", "restricted resource '%s' does not exist", resID)
+		}
+	}
+}
+
+func (v *validator) validateResource(res *gabs.Container, resPath string, columns map[string]*resourceColumns) {
+	resType, ok := res.Path("resType").Data().(string)
+	if !ok {
+		v.errorf(resPath+".resType", "missing resource type")
+	} else if !isRegisteredResourceType(resType) {
+		if _, err := newDataResourceParser(resType, v.rootPath, v.options); err == nil {
+			v.warnf(resPath+".resType", "unrecognized resource type '%s' loaded as a collection", resType)
+		} else {
+			v.errorf(resPath+".resType", "unrecognized resource type '%s'", resType)
+		}
+	}
+
+	// the resources parsed as media are collections unless stated otherwise.
+	isCollection, ok := res.Path("isCollection").Data().(bool)
+	if !ok {
+		isCollection = defaultsToCollection(resType, v.rootPath, v.options)
+	}
+	filePath, ok := res.Path("resPath").Data().(string)
+	if !ok {
+		v.errorf(resPath+".resPath", "missing resource path")
+	} else if v.rootPath != "" {
+		info, err := os.Stat(filepath.Join(v.rootPath, filePath))
+		if err != nil {
+			v.errorf(resPath+".resPath", "resource path '%s' does not exist", filePath)
+			filePath = ""
+		} else if isCollection && !info.IsDir() {
+			v.errorf(resPath+".resPath", "collection path '%s' is not a directory", filePath)
+			filePath = ""
+		} else if !isCollection && info.IsDir() {
+			v.errorf(resPath+".resPath", "resource path '%s' is a directory", filePath)
+			filePath = ""
+		}
+	}
+
+	if res.Path("columns").Data() == nil {
+		return
+	}
+	cols, err := res.Path("columns").Children()
+	if err != nil {
+		v.errorf(resPath+".columns", "columns are not a list")
+		return
+	}
+
+	names := make(map[string]bool)
+	indices := make(map[int]bool)
+	declared := make(map[int]*declaredColumn)
+	for j, col := range cols {
+		colPath := fmt.Sprintf("%s.columns[%d]", resPath, j)
+
+		name, ok := col.Path("colName").Data().(string)
+		if !ok {
+			v.errorf(colPath+".colName", "missing column name")
+		} else if names[name] {
+			v.warnf(colPath+".colName", "duplicate column name '%s'", name)
+		}
+		names[name] = true
+
+		// columns without an index are indexed by their position.
+		index, ok := float64(j), true
+		if col.Path("colIndex").Data() != nil {
+			index, ok = col.Path("colIndex").Data().(float64)
+		}
+		if !ok {
+			v.errorf(colPath+".colIndex", "column index is not a number")
+		} else if indices[int(index)] {
+			v.errorf(colPath+".colIndex", "duplicate column index %d", int(index))
+		} else {
+			indices[int(index)] = true
+			declared[int(index)] = &declaredColumn{
+				name: name,
+				path: colPath,
+			}
+		}
+
+		if col.Path("colType").Data() == nil {
+			v.warnf(colPath+".colType", "missing column type")
+		}
+		if roles, err := col.Path("role").Children(); err != nil {
+			v.warnf(colPath+".role", "missing column role")
+		} else {
+			for k, r := range roles {
+				if role, _ := r.Data().(string); schemaRoles[role] == "" {
+					v.warnf(fmt.Sprintf("%s.role[%d]", colPath, k), "unrecognized column role '%v'", r.Data())
+				}
+			}
+		}
+
+		if col.Path("refersTo").Data() != nil {
+			v.validateReference(col.Path("refersTo"), colPath+".refersTo", columns)
+		}
+	}
+
+	if v.rootPath != "" && filePath != "" && !isCollection && !v.merged && len(declared) > 0 {
+		v.validateHeader(filepath.Join(v.rootPath, filePath), resPath, declared)
+	}
+}
+
+// validateReference checks that the referenced resource exists and, when
+// the reference is to a column, that the column is declared. Columns are
+// referenced by name or by index.
+func (v *validator) validateReference(ref *gabs.Container, refPath string, columns map[string]*resourceColumns) {
+	resID, ok := ref.Path("resID").Data().(string)
+	if !ok {
+		v.errorf(refPath+".resID", "missing referenced resource id")
+		return
+	}
+	resColumns, ok := columns[resID]
+	if !ok {
+		v.errorf(refPath+".resID", "referenced resource '%s' does not exist", resID)
+		return
+	}
+
+	if ref.Path("resObject").Data() == nil {
+		return
+	}
+	if _, ok := ref.Path("resObject").Data().(string); ok {
+		return
+	}
+	objects, err := ref.Path("resObject").ChildrenMap()
+	if err != nil {
+		v.errorf(refPath+".resObject", "resource object is neither a string nor an object")
+		return
+	}
+	for k, o := range objects {
+		if k == "columnIndex" {
+			index, ok := o.Data().(float64)
+			if !ok || index != float64(int(index)) {
+				v.errorf(refPath+".resObject.columnIndex", "referenced column index is not an integer")
+			} else if index < 0 || int(index) >= resColumns.count {
+				v.errorf(refPath+".resObject.columnIndex", "referenced column index %d is outside of the %d columns of resource '%s'", int(index), resColumns.count, resID)
+			}
+			continue
+		}
+		value, ok := o.Data().(string)
+		if !ok {
+			v.errorf(fmt.Sprintf("%s.resObject.%s", refPath, k), "only column names and indices are supported as references")
+			continue
+		}
+		if k == "columnName" && !resColumns.names[value] {
+			v.errorf(refPath+".resObject.columnName", "referenced column '%s' does not exist in resource '%s'", value, resID)
+		}
+	}
+}
+
+// validateHeader checks the declared columns against the header of the
+// resource file.
+func (v *validator) validateHeader(filename string, resPath string, declared map[int]*declaredColumn) {
+	f, err := os.Open(filename)
+	if err != nil {
+		v.errorf(resPath+".resPath", "unable to open resource file: %v", err)
+		return
+	}
+	defer f.Close()
+
+	input := bufio.NewReader(f)
+	encoding := readEncoding(input)
+	if !isReadableEncoding(encoding) {
+		v.errorf(resPath+".resPath", "resource file is encoded in %s, which is not supported", encoding)
+		return
+	}
+	header, err := csv.NewReader(input).Read()
+	if err != nil {
+		v.errorf(resPath+".resPath", "unable to read resource file header: %v", err)
+		return
+	}
+
+	if len(header) != len(declared) {
+		v.errorf(resPath+".columns", "%d columns declared but the header has %d", len(declared), len(header))
+	}
+	indices := make([]int, 0, len(declared))
+	for index := range declared {
+		indices = append(indices, index)
+	}
+	sort.Ints(indices)
+	for _, index := range indices {
+		col := declared[index]
+		if index < 0 || index >= len(header) {
+			v.errorf(col.path+".colIndex", "index %d is outside of the header", index)
+		} else if header[index] != col.name {
+			v.errorf(col.path+".colName", "column '%s' does not match header '%s'", col.name, header[index])
+		}
+	}
+}
@@ -0,0 +1,346 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package metadata
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/jeffail/gabs"
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-compute/model"
+)
+
+const (
+	// SchemaVersion3 is the latest 3.x version of the dataset schema, which
+	// lists the formats of a resource.
+	SchemaVersion3 = "3.2.0"
+	// SchemaVersion4 is the 4.x version of the dataset schema, which maps
+	// the formats of a resource to their file extensions.
+	SchemaVersion4 = "4.0.0"
+
+	resTypeVideo  = "video"
+	resTypeSpeech = "speech"
+)
+
+var (
+	// formatExtensions lists the file extensions of the common formats, to
+	// write the formats of resources parsed from a 3.x schema.
+	formatExtensions = map[string][]string{
+		"text/csv":   {"csv"},
+		"text/plain": {"txt"},
+		"image/jpeg": {"jpeg", "jpg"},
+		"image/png":  {"png"},
+		"audio/wav":  {"wav"},
+		"audio/mpeg": {"mp3"},
+		"video/mp4":  {"mp4"},
+		"video/avi":  {"avi"},
+	}
+
+	// schemaRoles lists the column roles of the supported schema versions
+	// along with the role the ingest selects for a variable having them.
+	schemaRoles = map[string]string{
+		"index":                   model.VarRoleIndex,
+		"multiIndex":              model.VarRoleIndex,
+		"key":                     "key",
+		"attribute":               "attribute",
+		"suggestedTarget":         "suggestedTarget",
+		"suggestedPrivilegedData": "attribute",
+		"suggestedGroupingKey":    "attribute",
+		"timeIndicator":           "attribute",
+		"locationIndicator":       "attribute",
+		"boundaryIndicator":       "attribute",
+		"boundingBox":             "attribute",
+		"boundingPolygon":         "attribute",
+		"instanceWeight":          "attribute",
+		"interval":                "attribute",
+		"edgeSource":              "attribute",
+		"directedEdgeSource":      "attribute",
+		"undirectedEdgeSource":    "attribute",
+		"multiEdgeSource":         "attribute",
+		"simpleEdgeSource":        "attribute",
+		"edgeTarget":              "attribute",
+		"directedEdgeTarget":      "attribute",
+		"undirectedEdgeTarget":    "attribute",
+		"multiEdgeTarget":         "attribute",
+		"simpleEdgeTarget":        "attribute",
+	}
+
+	// roleAliases maps the roles of a major version to their equivalent in
+	// the other, for the roles which were renamed or added by 4.x.
+	roleAliases = map[int]map[string]string{
+		3: {
+			"multiIndex":           "index",
+			"boundingPolygon":      "boundingBox",
+			"suggestedGroupingKey": "attribute",
+		},
+		4: {
+			"boundingBox": "boundingPolygon",
+		},
+	}
+)
+
+// Quality is a quality of the dataset, optionally restricted to a resource
+// or one of its components.
+type Quality struct {
+	Name         string                 `json:"qualName"`
+	Value        interface{}            `json:"qualValue"`
+	ValueType    string                 `json:"qualValueType,omitempty"`
+	ValueUnits   string                 `json:"qualValueUnits,omitempty"`
+	RestrictedTo map[string]interface{} `json:"restrictedTo,omitempty"`
+}
+
+// SchemaVersion returns the version of the schema the metadata was loaded
+// from, defaulting to the version written when there is no schema.
+func SchemaVersion(m *model.Metadata) string {
+	if m.Schema == nil {
+		return schemaVersion
+	}
+	version, err := parseSchemaVersion(m.Schema)
+	if err != nil {
+		return schemaVersion
+	}
+	return version
+}
+
+// CheckSchemaVersion checks that schemas can be written in the version.
+func CheckSchemaVersion(version string) error {
+	_, err := schemaMajorVersion(version)
+	return err
+}
+
+func parseQuality(q *gabs.Container) (*Quality, error) {
+	name, ok := q.Path("qualName").Data().(string)
+	if !ok {
+		return nil, errors.New("missing quality name")
+	}
+	if q.Path("qualValue").Data() == nil {
+		return nil, errors.Errorf("missing value of quality '%s'", name)
+	}
+
+	quality := &Quality{
+		Name:  name,
+		Value: q.Path("qualValue").Data(),
+	}
+	quality.ValueType, _ = q.Path("qualValueType").Data().(string)
+	quality.ValueUnits, _ = q.Path("qualValueUnits").Data().(string)
+	if q.Path("restrictedTo").Data() != nil {
+		restrictedTo, ok := q.Path("restrictedTo").Data().(map[string]interface{})
+		if !ok {
+			return nil, errors.Errorf("restriction of quality '%s' is not an object", name)
+		}
+		quality.RestrictedTo = restrictedTo
+	}
+	return quality, nil
+}
+
+// selectedRole picks the role of the variable used by the ingest from the
+// first recognized role of the column, defaulting to its first role.
+func selectedRole(roles []string) string {
+	for _, r := range roles {
+		if selected, ok := schemaRoles[r]; ok {
+			return selected
+		}
+	}
+	if len(roles) > 0 {
+		return roles[0]
+	}
+	return ""
+}
+
+// versionRoles maps the roles of a column to their equivalent in the major
+// version, dropping the duplicates of the mapped roles.
+func versionRoles(roles []string, major int) []string {
+	mapped := make([]string, 0, len(roles))
+	added := make(map[string]bool)
+	for _, r := range roles {
+		if alias, ok := roleAliases[major][r]; ok {
+			r = alias
+		}
+		if !added[r] {
+			mapped = append(mapped, r)
+			added[r] = true
+		}
+	}
+	return mapped
+}
+
+// targetSchemaVersion resolves the version written for the target version.
+// The version of the source schema is kept when both share the major
+// version, as the minor versions share their layout.
+func targetSchemaVersion(m *model.Metadata, version string) (string, error) {
+	major, err := schemaMajorVersion(version)
+	if err != nil {
+		return "", err
+	}
+	if m.Schema == nil {
+		return version, nil
+	}
+	source, err := parseSchemaVersion(m.Schema)
+	if err != nil {
+		return version, nil
+	}
+	sourceMajor, _ := schemaMajorVersion(source)
+	if sourceMajor == major {
+		return source, nil
+	}
+	return version, nil
+}
+
+// parseSchemaVersion reads the version of a schema document, which is only
+// missing from the early 3.x versions.
+func parseSchemaVersion(schema *gabs.Container) (string, error) {
+	version, ok := schema.Path("about.datasetSchemaVersion").Data().(string)
+	if !ok {
+		return schemaVersion, nil
+	}
+
+	_, err := schemaMajorVersion(version)
+	if err != nil {
+		return "", err
+	}
+	return version, nil
+}
+
+// schemaMajorVersion parses the major version of a supported schema
+// version.
+func schemaMajorVersion(version string) (int, error) {
+	major, err := strconv.Atoi(strings.SplitN(version, ".", 2)[0])
+	if err != nil {
+		return 0, errors.Wrapf(err, "failed to parse schema version '%s'", version)
+	}
+	if major < 3 || major > 4 {
+		return 0, errors.Errorf("unsupported schema version '%s'", version)
+	}
+	return major, nil
+}
+
+// parseResourceFormats reads the formats of a resource, which are either a
+// list of formats or, from version 4, a map of the formats to their
+// extensions.
+func parseResourceFormats(res *gabs.Container) ([]string, error) {
+	if res.Path("resFormat").Data() == nil {
+		return make([]string, 0), nil
+	}
+
+	if formatsMap, err := res.Path("resFormat").ChildrenMap(); err == nil {
+		resFormats := make([]string, 0, len(formatsMap))
+		for format := range formatsMap {
+			resFormats = append(resFormats, format)
+		}
+		sort.Strings(resFormats)
+		return resFormats, nil
+	}
+
+	formatsRaw, err := res.Path("resFormat").Children()
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse resource format")
+	}
+	resFormats := make([]string, len(formatsRaw))
+	for i, r := range formatsRaw {
+		format, ok := r.Data().(string)
+		if !ok {
+			return nil, errors.Errorf("unable to parse resource format %d", i)
+		}
+		resFormats[i] = format
+	}
+	return resFormats, nil
+}
+
+// resourceDocument builds the document of a data resource for the schema
+// version, on top of the original resource and columns so that the keys
+// unknown to the model are kept. The roles of the columns are mapped to
+// their equivalent in the version. The file extensions of a 4.x resource are
+// copied from the original resource when available.
+func resourceDocument(dr *model.DataResource, version string, original *gabs.Container, originalColumns map[string]*gabs.Container) (map[string]interface{}, error) {
+	major, err := schemaMajorVersion(version)
+	if err != nil {
+		return nil, err
+	}
+
+	bytes, err := json.Marshal(dr)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to marshal data resource")
+	}
+	doc := make(map[string]interface{})
+	err = json.Unmarshal(bytes, &doc)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to unmarshal data resource")
+	}
+
+	if columns, ok := doc["columns"].([]interface{}); ok {
+		for i, v := range dr.Variables {
+			column := columns[i].(map[string]interface{})
+			if v.Role != nil {
+				column["role"] = versionRoles(v.Role, major)
+			}
+			if col := originalColumns[v.Name]; col != nil {
+				column = mergeDocument(col.Data(), column, variableKeys)
+			}
+			columns[i] = column
+		}
+	}
+	if original != nil {
+		doc = mergeDocument(original.Data(), doc, resourceKeys)
+		// the column count of the newer schemas follows the added variables.
+		if _, ok := doc["columnsCount"]; ok {
+			doc["columnsCount"] = len(dr.Variables)
+		}
+	}
+
+	if major < 4 {
+		return doc, nil
+	}
+
+	var originalFormats map[string]*gabs.Container
+	if original != nil {
+		originalFormats, _ = original.Path("resFormat").ChildrenMap()
+	}
+
+	formats := make(map[string]interface{})
+	for _, format := range dr.ResFormat {
+		if originalFormats[format] != nil {
+			formats[format] = originalFormats[format].Data()
+		} else if extensions, ok := formatExtensions[format]; ok {
+			formats[format] = extensions
+		} else {
+			formats[format] = []string{format[strings.LastIndex(format, "/")+1:]}
+		}
+	}
+	doc["resFormat"] = formats
+
+	return doc, nil
+}
+
+// schemaResource finds the resource of the schema document with the id.
+func schemaResource(schema *gabs.Container, resID string) *gabs.Container {
+	if schema == nil {
+		return nil
+	}
+	resources, err := schema.Path("dataResources").Children()
+	if err != nil {
+		return nil
+	}
+	for _, res := range resources {
+		if id, ok := res.Path("resID").Data().(string); ok && id == resID {
+			return res
+		}
+	}
+	return nil
+}
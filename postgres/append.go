@@ -0,0 +1,102 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg"
+	"github.com/pkg/errors"
+
+	api "github.com/uncharted-distil/distil-compute/model"
+	"github.com/unchartedsoftware/plog"
+)
+
+const (
+	appendRowsSQL = `INSERT INTO %s_base (%s)
+		SELECT DISTINCT ON (s."%s") %s FROM %s_base s
+		WHERE NOT EXISTS (SELECT 1 FROM %s_base b WHERE b."%s" = s."%s");`
+)
+
+// FetchVariableNames reads the names of the variables stored for a dataset.
+func (d *Database) FetchVariableNames(name string) ([]string, error) {
+	variableTableName := fmt.Sprintf("%s%s", name, variableTableSuffix)
+
+	var names pg.Strings
+	_, err := d.DB.Query(&names, fmt.Sprintf("SELECT name FROM %s;", variableTableName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read variables from %s", variableTableName)
+	}
+
+	return names, nil
+}
+
+// AppendDataset inserts the staged rows into an existing dataset, skipping
+// rows whose d3mIndex is already present. The result and variable tables of
+// the existing dataset are left untouched, and the staging tables are
+// removed once the rows are appended.
+func (d *Database) AppendDataset(stagingName string, name string) (int, error) {
+	ds := d.Tables[stagingName]
+	if ds == nil {
+		return 0, errors.Errorf("dataset %s has not been initialized", stagingName)
+	}
+
+	columns := make([]string, len(ds.Variables))
+	for i, v := range ds.Variables {
+		columns[i] = fmt.Sprintf("\"%s\"", v.Name)
+	}
+	columnList := strings.Join(columns, ", ")
+
+	log.Infof("Appending dataset %s to %s", stagingName, name)
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return 0, errors.Wrap(err, "unable to start append transaction")
+	}
+
+	res, err := tx.Exec(fmt.Sprintf(appendRowsSQL, name, columnList, api.D3MIndexName,
+		columnList, stagingName, name, api.D3MIndexName, api.D3MIndexName))
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrapf(err, "unable to append rows to dataset %s", name)
+	}
+	appended := res.RowsAffected()
+
+	stemTableName := fmt.Sprintf("%s_%s", stagingName, wordStemTableName)
+	_, err = tx.Exec(fmt.Sprintf("INSERT INTO %s SELECT * FROM %s ON CONFLICT (stem) DO NOTHING;", wordStemTableName, stemTableName))
+	if err != nil {
+		tx.Rollback()
+		return 0, errors.Wrapf(err, "unable to append word stems of dataset %s", name)
+	}
+
+	for _, statement := range d.mergeCastErrorStatements(stagingName, name) {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			tx.Rollback()
+			return 0, errors.Wrapf(err, "unable to append cast errors of dataset %s", name)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to commit append to dataset %s", name)
+	}
+
+	d.DiscardStaging(stagingName)
+
+	return appended, nil
+}
@@ -0,0 +1,130 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/unchartedsoftware/plog"
+)
+
+const (
+	// InsertLoader loads rows using batched multi-row INSERT statements.
+	InsertLoader = "insert"
+	// CopyLoader streams rows into the base table using COPY FROM STDIN.
+	CopyLoader = "copy"
+
+	copyStatementSQL = "COPY %s_base FROM STDIN WITH (FORMAT csv);"
+)
+
+// copyStream is an open COPY FROM STDIN statement fed by a pipe.
+type copyStream struct {
+	tableName string
+	pipe      *io.PipeWriter
+	writer    *bufio.Writer
+	done      chan error
+	count     int
+}
+
+// openCopy starts a COPY statement into the base table of the dataset.
+func (d *Database) openCopy(tableName string) *copyStream {
+	reader, writer := io.Pipe()
+	stream := &copyStream{
+		tableName: tableName,
+		pipe:      writer,
+		writer:    bufio.NewWriter(writer),
+		done:      make(chan error, 1),
+	}
+
+	log.Debugf("Starting copy into table %s_base", tableName)
+	go func() {
+		_, err := d.DB.CopyFrom(reader, fmt.Sprintf(copyStatementSQL, tableName))
+		// unblock the writer if the copy ended before the input did
+		reader.CloseWithError(err)
+		stream.done <- err
+	}()
+
+	return stream
+}
+
+func (s *copyStream) write(values []interface{}) error {
+	_, err := s.writer.WriteString(encodeCopyRow(values))
+	if err != nil {
+		return errors.Wrap(err, "unable to copy to table "+s.tableName)
+	}
+	s.count = s.count + 1
+
+	return nil
+}
+
+func (s *copyStream) finish() error {
+	err := s.writer.Flush()
+	if err != nil {
+		s.pipe.CloseWithError(err)
+	} else {
+		s.pipe.Close()
+	}
+
+	// the result of the copy statement holds the actual cause
+	err = <-s.done
+	if err != nil {
+		return errors.Wrap(err, "unable to copy to table "+s.tableName)
+	}
+	log.Debugf("Copied %d rows into table %s_base", s.count, s.tableName)
+
+	return nil
+}
+
+func (s *copyStream) abort() {
+	s.pipe.CloseWithError(errors.New("copy aborted"))
+	<-s.done
+	log.Warnf("Aborted copy into table %s_base", s.tableName)
+}
+
+// copyRows streams the rows into the base table with a single COPY
+// statement.
+func (d *Database) copyRows(tableName string, rows [][]interface{}) error {
+	stream := d.openCopy(tableName)
+	for _, values := range rows {
+		err := stream.write(values)
+		if err != nil {
+			stream.abort()
+			return err
+		}
+	}
+
+	return stream.finish()
+}
+
+// encodeCopyRow writes the values as a line of COPY csv input. Nil values are
+// left unquoted so that postgres reads them as NULL, while every other value
+// is quoted so empty strings are preserved.
+func encodeCopyRow(values []interface{}) string {
+	fields := make([]string, len(values))
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		field := fmt.Sprintf("%v", v)
+		fields[i] = fmt.Sprintf("\"%s\"", strings.Replace(field, "\"", "\"\"", -1))
+	}
+
+	return strings.Join(fields, ",") + "\n"
+}
@@ -0,0 +1,128 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+
+	api "github.com/uncharted-distil/distil-compute/model"
+	"github.com/unchartedsoftware/plog"
+)
+
+// NewVariables returns the variables of the metadata which are not yet
+// stored for the dataset. An error is returned if a stored variable is no
+// longer present in the metadata.
+func (d *Database) NewVariables(name string, meta *api.Metadata) ([]*api.Variable, error) {
+	stored, err := d.FetchVariableNames(name)
+	if err != nil {
+		return nil, err
+	}
+
+	storedLookup := make(map[string]bool)
+	for _, v := range stored {
+		storedLookup[v] = true
+	}
+
+	metaLookup := make(map[string]bool)
+	added := make([]*api.Variable, 0)
+	for _, v := range meta.DataResources[0].Variables {
+		metaLookup[v.Name] = true
+		if !storedLookup[v.Name] {
+			added = append(added, v)
+		}
+	}
+
+	for _, v := range stored {
+		if !metaLookup[v] {
+			return nil, errors.Errorf("stored variable %s is missing from the metadata", v)
+		}
+	}
+
+	return added, nil
+}
+
+// EvolveDataset adds new variables to an existing dataset. The base table
+// is altered to hold the new columns, which are backfilled from the staged
+// rows by d3mIndex, and the variable table and typed view are rebuilt to
+// include the new variables. The new columns use the storage mode stored
+// with the dataset, which the staged rows are expected to share. The
+// staging tables are removed once done.
+func (d *Database) EvolveDataset(stagingName string, name string, added []*api.Variable) error {
+	ds := d.Tables[stagingName]
+	if ds == nil {
+		return errors.Errorf("dataset %s has not been initialized", stagingName)
+	}
+
+	log.Infof("Adding %d variables from dataset %s to %s", len(added), stagingName, name)
+
+	// the added columns are stored as the dataset was created.
+	typed, err := d.FetchTypedStorage(name)
+	if err != nil {
+		return err
+	}
+
+	// the view reads the search columns, which may be replaced.
+	statements := []string{
+		fmt.Sprintf("DROP VIEW %s;", name),
+	}
+	if len(added) > 0 {
+		columns := make([]string, len(added))
+		updates := make([]string, len(added))
+		for i, v := range added {
+			columns[i] = fmt.Sprintf("ADD COLUMN \"%s\" %s", v.Name, baseColumnType(v, typed))
+			updates[i] = fmt.Sprintf("\"%s\" = s.\"%s\"", v.Name, v.Name)
+		}
+		statements = append(statements,
+			fmt.Sprintf("ALTER TABLE %s_base %s;", name, strings.Join(columns, ", ")),
+			fmt.Sprintf("UPDATE %s_base b SET %s FROM %s_base s WHERE b.\"%s\" = s.\"%s\";",
+				name, strings.Join(updates, ", "), stagingName, api.D3MIndexName, api.D3MIndexName))
+	}
+	statements = append(statements, d.searchColumnStatements(name, ds.Variables, added)...)
+	statements = append(statements, d.geographyColumnStatements(name, ds.Variables)...)
+	statements = append(statements,
+		d.createViewStatement(name, ds.Variables, typed),
+		// the staged variable table replaces the existing one, which may
+		// have been created without the stats column.
+		fmt.Sprintf("DROP TABLE %s%s;", name, variableTableSuffix),
+		fmt.Sprintf("ALTER TABLE %s%s RENAME TO %s%s;", stagingName, variableTableSuffix, name, variableTableSuffix),
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s_%s ON CONFLICT (stem) DO NOTHING;", wordStemTableName, stagingName, wordStemTableName))
+	statements = append(statements, d.mergeCastErrorStatements(stagingName, name)...)
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "unable to start evolution transaction")
+	}
+
+	for _, statement := range statements {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "unable to add variables to dataset %s", name)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "unable to commit variables added to dataset %s", name)
+	}
+
+	d.DiscardStaging(stagingName)
+
+	return nil
+}
@@ -0,0 +1,101 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+
+	api "github.com/uncharted-distil/distil-compute/model"
+	"github.com/uncharted-distil/distil-ingest/metadata"
+	"github.com/unchartedsoftware/plog"
+)
+
+const (
+	// coordinatePattern matches the numbers which can be cast to a
+	// coordinate, with bounded digits so that the cast cannot overflow.
+	coordinatePattern = `^\s*[-+]?([0-9]{1,3}\.?[0-9]*|\.[0-9]+)([eE][-+]?[0-9]{1,2})?\s*$`
+
+	// the points are only computed for values holding numbers in range,
+	// which leaves the column empty for the others.
+	geographyColumnDefinition = `geography(Point, 4326) GENERATED ALWAYS AS (CASE
+			WHEN CAST("%[1]s" AS TEXT) ~ '%[3]s' AND CAST("%[2]s" AS TEXT) ~ '%[3]s' THEN CASE
+				WHEN %[4]s BETWEEN -90 AND 90 AND %[5]s BETWEEN -180 AND 180
+				THEN CAST(ST_SetSRID(ST_MakePoint(%[5]s, %[4]s), 4326) AS geography) END
+		END) STORED`
+)
+
+// geographyColumns lists the generated columns storing the geo pairs of the
+// variables as PostGIS points. Being generated, the points of appended rows
+// and added variables are computed along with the rows.
+func (d *Database) geographyColumns(variables []*api.Variable) []*generatedColumn {
+	if !d.Geography {
+		return nil
+	}
+
+	pairs := metadata.GeoPairs(variables)
+	columns := make([]*generatedColumn, len(pairs))
+	for i, pair := range pairs {
+		lat := fmt.Sprintf("CAST(CAST(\"%s\" AS TEXT) AS double precision)", pair.Latitude.Name)
+		lon := fmt.Sprintf("CAST(CAST(\"%s\" AS TEXT) AS double precision)", pair.Longitude.Name)
+		columns[i] = &generatedColumn{
+			name: pair.Name,
+			definition: fmt.Sprintf(geographyColumnDefinition,
+				pair.Latitude.Name, pair.Longitude.Name, coordinatePattern, lat, lon),
+		}
+	}
+
+	return columns
+}
+
+// enableGeography makes sure the PostGIS extension is available before the
+// geography columns of the variables are created.
+func (d *Database) enableGeography(variables []*api.Variable) error {
+	if len(d.geographyColumns(variables)) == 0 {
+		return nil
+	}
+
+	_, err := d.DB.Exec("CREATE EXTENSION IF NOT EXISTS postgis;")
+	if err != nil {
+		return errors.Wrap(err, "unable to enable the postgis extension")
+	}
+
+	return nil
+}
+
+// geographyColumnStatements returns the statements adding the geography
+// columns missing from the base table of an existing dataset.
+func (d *Database) geographyColumnStatements(tableName string, variables []*api.Variable) []string {
+	statements := make([]string, 0)
+	for _, c := range d.geographyColumns(variables) {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s_base ADD COLUMN IF NOT EXISTS \"%s\" %s;", tableName, c.name, c.definition))
+	}
+
+	return statements
+}
+
+// createGeographyIndexes creates a GiST index on every geography column of
+// the base table.
+func (d *Database) createGeographyIndexes(tableName string, variables []*api.Variable) {
+	for _, c := range d.geographyColumns(variables) {
+		err := d.createIndex(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s_base USING gist (\"%s\");",
+			indexName(tableName, c.name), tableName, c.name))
+		if err != nil {
+			log.Warnf("unable to create geography index on %s: %v", c.name, err)
+		}
+	}
+}
@@ -0,0 +1,287 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/go-pg/pg"
+	"github.com/pkg/errors"
+
+	api "github.com/uncharted-distil/distil-compute/model"
+	"github.com/unchartedsoftware/plog"
+)
+
+const (
+	// IndexKey creates a unique index on the d3mIndex variable.
+	IndexKey = "key"
+	// IndexCategorical creates B-tree indexes on categorical variables.
+	IndexCategorical = "categorical"
+	// IndexNumerical creates B-tree indexes on numerical variables.
+	IndexNumerical = "numerical"
+	// IndexText creates GIN indexes on text variables.
+	IndexText = "text"
+
+	indexNone = "none"
+	indexAll  = "all"
+
+	dateTimeType = "dateTime"
+
+	// maxIdentifierLength is the length postgres truncates identifiers to.
+	maxIdentifierLength = 63
+	// indexSuffixLength is the length of the column hash and suffix of the
+	// index names, and tableHashLength the length of the table hash.
+	indexSuffixLength = len("_00000000_idx")
+	tableHashLength   = len("_00000000")
+)
+
+var (
+	categoricalIndexTypes = map[string]bool{
+		"categorical": true,
+		"ordinal":     true,
+		"boolean":     true,
+		"address":     true,
+		"city":        true,
+		"state":       true,
+		"country":     true,
+		"email":       true,
+		"phone":       true,
+		"postal_code": true,
+		"uri":         true,
+	}
+	numericalIndexTypes = map[string]bool{
+		"integer":   true,
+		"float":     true,
+		"real":      true,
+		"latitude":  true,
+		"longitude": true,
+	}
+	textIndexTypes = map[string]bool{
+		"text": true,
+	}
+)
+
+// IndexPolicy lists the kinds of indexes created once a dataset is loaded.
+type IndexPolicy struct {
+	kinds map[string]bool
+}
+
+// ParseIndexPolicy parses a comma separated list of index kinds. The
+// special values `all` and `none` enable or disable every kind.
+func ParseIndexPolicy(spec string) (*IndexPolicy, error) {
+	policy := &IndexPolicy{
+		kinds: make(map[string]bool),
+	}
+
+	for _, kind := range strings.Split(spec, ",") {
+		kind = strings.TrimSpace(kind)
+		switch kind {
+		case "", indexNone:
+			continue
+		case indexAll:
+			policy.kinds[IndexKey] = true
+			policy.kinds[IndexCategorical] = true
+			policy.kinds[IndexNumerical] = true
+			policy.kinds[IndexText] = true
+		case IndexKey, IndexCategorical, IndexNumerical, IndexText:
+			policy.kinds[kind] = true
+		default:
+			return nil, errors.Errorf("unrecognized index kind '%s'", kind)
+		}
+	}
+
+	return policy, nil
+}
+
+// Includes indicates whether or not the policy creates indexes of the kind.
+func (p *IndexPolicy) Includes(kind string) bool {
+	return p.kinds[kind]
+}
+
+// String lists the kinds of indexes of the policy as parsed by
+// ParseIndexPolicy.
+func (p *IndexPolicy) String() string {
+	kinds := make([]string, 0, len(p.kinds))
+	for kind := range p.kinds {
+		kinds = append(kinds, kind)
+	}
+	if len(kinds) == 0 {
+		return indexNone
+	}
+	sort.Strings(kinds)
+
+	return strings.Join(kinds, ",")
+}
+
+// FetchIndexPolicy reads the index policy stored for a dataset. The policy of
+// the database is returned for datasets ingested without one.
+func (d *Database) FetchIndexPolicy(name string) (*IndexPolicy, error) {
+	settings, err := d.fetchTableSettings(name)
+	if err != nil {
+		return nil, err
+	}
+	policy, ok := settings[indexPolicySetting]
+	if !ok {
+		return d.IndexPolicy, nil
+	}
+
+	return ParseIndexPolicy(policy)
+}
+
+// CreateIndexes creates the indexes selected by the index policy and the
+// indexes of the search and geography columns on the base table of a
+// dataset, then updates the table statistics. Indexes are built on the
+// expressions of the view, following the storage mode of the dataset, so
+// that filters on the view can use them. The policy is stored with the
+// dataset so that variables added later are indexed the same way.
+func (d *Database) CreateIndexes(tableName string, variables []*api.Variable, policy *IndexPolicy) error {
+	baseTableName := fmt.Sprintf("%s_base", tableName)
+
+	err := d.storeTableSetting(tableName, indexPolicySetting, policy.String())
+	if err != nil {
+		return err
+	}
+
+	// the index expressions match the view of the stored columns.
+	typed, err := d.FetchTypedStorage(tableName)
+	if err != nil {
+		return err
+	}
+
+	for _, v := range variables {
+		var statement string
+		switch {
+		case v.Name == api.D3MIndexName:
+			if !policy.Includes(IndexKey) {
+				continue
+			}
+			err := d.createIndex(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s (\"%s\");",
+				indexName(tableName, v.Name), baseTableName, v.Name))
+			if err == nil {
+				continue
+			}
+			log.Warnf("unable to create unique index on %s, falling back to a non unique index: %v", v.Name, err)
+			statement = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s (\"%s\");",
+				indexName(tableName, v.Name), baseTableName, v.Name)
+		case categoricalIndexTypes[v.Type] && policy.Includes(IndexCategorical),
+			numericalIndexTypes[v.Type] && policy.Includes(IndexNumerical),
+			// casting text to a timestamp depends on the date style so only
+			// typed timestamps can be indexed.
+			v.Type == dateTimeType && typed && policy.Includes(IndexNumerical):
+			statement = fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s ((%s));",
+				indexName(tableName, v.Name), baseTableName, viewColumnExpression(v, typed))
+		case textIndexTypes[v.Type] && policy.Includes(IndexText):
+			statement = d.textIndexStatement(tableName, v, typed)
+		default:
+			continue
+		}
+
+		// indexes only speed up queries so a failure does not fail the ingest.
+		err := d.createIndex(statement)
+		if err != nil {
+			log.Warnf("unable to create index on %s: %v", v.Name, err)
+		}
+	}
+
+	d.createSearchIndexes(tableName, variables)
+	d.createGeographyIndexes(tableName, variables)
+
+	return d.UpdateStatistics(tableName)
+}
+
+// UpdateStatistics updates the statistics of the base table of a dataset.
+func (d *Database) UpdateStatistics(tableName string) error {
+	baseTableName := fmt.Sprintf("%s_base", tableName)
+
+	log.Infof("Analyzing table %s", baseTableName)
+	_, err := d.DB.Exec(fmt.Sprintf("ANALYZE %s;", baseTableName))
+	if err != nil {
+		return errors.Wrapf(err, "unable to analyze table %s", baseTableName)
+	}
+
+	return nil
+}
+
+// textIndexStatement builds a trigram index for substring matching when the
+// pg_trgm extension is available, and a full text index otherwise.
+func (d *Database) textIndexStatement(tableName string, v *api.Variable, typed bool) string {
+	_, err := d.DB.Exec("CREATE EXTENSION IF NOT EXISTS pg_trgm;")
+	if err == nil {
+		return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s_base USING gin ((%s) gin_trgm_ops);",
+			indexName(tableName, v.Name), tableName, viewColumnExpression(v, typed))
+	}
+	log.Warnf("pg_trgm extension unavailable, using a full text index on %s: %v", v.Name, err)
+
+	return fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s_base USING gin (to_tsvector('english', %s));",
+		indexName(tableName, v.Name), tableName, viewColumnExpression(v, typed))
+}
+
+func (d *Database) createIndex(statement string) error {
+	log.Infof("Creating index: %s", statement)
+	_, err := d.DB.Exec(statement)
+
+	return err
+}
+
+// indexRenameStatements builds the statements renaming the indexes of the
+// base table of a dataset, which are prefixed by the dataset name when
+// created on ingest.
+func (d *Database) indexRenameStatements(name string, newName string) ([]string, error) {
+	var indexes pg.Strings
+	_, err := d.DB.Query(&indexes, listIndexesSQL, fmt.Sprintf("%s_base", name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to list indexes of dataset %s", name)
+	}
+
+	prefix := indexPrefix(name)
+	newPrefix := indexPrefix(newName)
+	statements := make([]string, 0)
+	for _, index := range indexes {
+		if strings.HasPrefix(index, prefix+"_") {
+			statements = append(statements, fmt.Sprintf("ALTER INDEX %s RENAME TO %s%s;",
+				index, newPrefix, strings.TrimPrefix(index, prefix)))
+		}
+	}
+
+	return statements, nil
+}
+
+// indexName derives a stable index name from the column name, which may be
+// too long or contain characters not suited to identifiers.
+func indexName(tableName string, column string) string {
+	return fmt.Sprintf("%s_%08x_idx", indexPrefix(tableName), crc32.ChecksumIEEE([]byte(column)))
+}
+
+// indexPrefix is the table part of the index names, which is shortened when
+// the names would exceed the identifier length postgres keeps. The shortened
+// table name is followed by its hash, as index names are unique within the
+// schema and long table names often only differ in their suffix.
+func indexPrefix(tableName string) string {
+	if len(tableName)+indexSuffixLength <= maxIdentifierLength {
+		return tableName
+	}
+
+	length := maxIdentifierLength - indexSuffixLength - tableHashLength
+	for length > 0 && !utf8.RuneStart(tableName[length]) {
+		length = length - 1
+	}
+
+	return fmt.Sprintf("%s_%08x", tableName[:length], crc32.ChecksumIEEE([]byte(tableName)))
+}
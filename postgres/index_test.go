@@ -0,0 +1,40 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestIndexName(t *testing.T) {
+	assert.Equal(t, "dataset_staging_9bf26d7b_idx", indexName(StagingName("dataset"), "d3mIndex"))
+
+	// long table names which only differ in their suffix do not collide.
+	long := strings.Repeat("a", 60)
+	first := indexName(StagingName(long+"_first"), "d3mIndex")
+	second := indexName(StagingName(long+"_second"), "d3mIndex")
+	assert.Equal(t, maxIdentifierLength, len(first))
+	assert.Equal(t, maxIdentifierLength, len(second))
+	assert.NotEqual(t, first, second)
+
+	// the promoted names are derived the same way.
+	assert.True(t, strings.HasPrefix(first, indexPrefix(StagingName(long+"_first"))+"_"))
+	assert.Equal(t, indexName(long+"_first", "d3mIndex"),
+		indexPrefix(long+"_first")+strings.TrimPrefix(first, indexPrefix(StagingName(long+"_first"))))
+}
@@ -0,0 +1,154 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg"
+	"github.com/pkg/errors"
+
+	"github.com/unchartedsoftware/plog"
+)
+
+const (
+	listDatasetsSQL = `SELECT v.table_name FROM information_schema.tables v
+		JOIN information_schema.tables b ON b.table_schema = v.table_schema
+			AND b.table_name = left(v.table_name, length(v.table_name) - ?) || '_base'
+		WHERE v.table_schema = current_schema() AND v.table_name LIKE ? AND v.table_name NOT LIKE ?
+		ORDER BY v.table_name;`
+	listIndexesSQL = `SELECT indexname FROM pg_indexes
+		WHERE schemaname = current_schema() AND tablename = ?;`
+)
+
+// DatasetSummary describes a dataset stored in the database.
+type DatasetSummary struct {
+	Name      string
+	Rows      int
+	Variables int
+}
+
+// StoredVariable is a variable as recorded in the variable table of a
+// dataset.
+type StoredVariable struct {
+	Name string
+	Role string
+	Type string
+}
+
+// ListDatasets lists the datasets having both a base table and a variable
+// table, along with their row and variable counts. Staged datasets, which
+// are left over by failed loads, are not listed.
+func (d *Database) ListDatasets() ([]*DatasetSummary, error) {
+	var tables pg.Strings
+	_, err := d.DB.Query(&tables, listDatasetsSQL, len(variableTableSuffix),
+		fmt.Sprintf("%%\\%s", variableTableSuffix),
+		fmt.Sprintf("%%\\%s\\%s", stagingSuffix, variableTableSuffix))
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to list datasets")
+	}
+
+	summaries := make([]*DatasetSummary, 0)
+	for _, table := range tables {
+		name := strings.TrimSuffix(table, variableTableSuffix)
+
+		rows, err := d.CountRows(name)
+		if err != nil {
+			return nil, err
+		}
+		var variables int
+		_, err = d.DB.QueryOne(pg.Scan(&variables), fmt.Sprintf("SELECT COUNT(*) FROM %s;", table))
+		if err != nil {
+			return nil, errors.Wrapf(err, "unable to count variables of %s", name)
+		}
+
+		summaries = append(summaries, &DatasetSummary{
+			Name:      name,
+			Rows:      rows,
+			Variables: variables,
+		})
+	}
+
+	return summaries, nil
+}
+
+// FetchVariables reads the variables stored for a dataset.
+func (d *Database) FetchVariables(name string) ([]*StoredVariable, error) {
+	variableTableName := fmt.Sprintf("%s%s", name, variableTableSuffix)
+
+	var variables []*StoredVariable
+	_, err := d.DB.Query(&variables, fmt.Sprintf("SELECT name, role, type FROM %s;", variableTableName))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read variables from %s", variableTableName)
+	}
+
+	return variables, nil
+}
+
+// RenameDataset renames the view, base, result and variable tables of a
+// dataset in a single transaction, along with the cast error table and the
+// indexes of the base table.
+func (d *Database) RenameDataset(name string, newName string) error {
+	exists, err := d.DatasetExists(newName)
+	if err != nil {
+		return err
+	}
+	if exists {
+		return errors.Errorf("dataset %s already exists", newName)
+	}
+
+	indexStatements, err := d.indexRenameStatements(name, newName)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("Renaming dataset %s to %s", name, newName)
+
+	statements := []string{
+		fmt.Sprintf("ALTER VIEW %s RENAME TO %s;", name, newName),
+		fmt.Sprintf("ALTER TABLE %s_base RENAME TO %s_base;", name, newName),
+		fmt.Sprintf("ALTER TABLE %s%s RENAME TO %s%s;", name, resultTableSuffix, newName, resultTableSuffix),
+		fmt.Sprintf("ALTER TABLE %s%s RENAME TO %s%s;", name, variableTableSuffix, newName, variableTableSuffix),
+		fmt.Sprintf("ALTER TABLE IF EXISTS %s%s RENAME TO %s%s;", name, castErrorTableSuffix, newName, castErrorTableSuffix),
+	}
+	statements = append(statements, indexStatements...)
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "unable to start rename transaction")
+	}
+
+	for _, statement := range statements {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "unable to rename dataset %s", name)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "unable to commit rename of dataset %s", name)
+	}
+
+	if ds, ok := d.Tables[name]; ok {
+		d.Tables[newName] = ds
+		delete(d.Tables, name)
+	}
+
+	return nil
+}
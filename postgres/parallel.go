@@ -0,0 +1,247 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/go-pg/pg"
+	"github.com/pkg/errors"
+
+	"github.com/uncharted-distil/distil-ingest/postgres/model"
+	"github.com/unchartedsoftware/plog"
+)
+
+// Row is a line of raw csv data along with its line number in the source.
+type Row struct {
+	Number int
+	Data   string
+}
+
+// RejectFunc is called for every row which could not be ingested.
+type RejectFunc func(row *Row, rowErr *RowError) error
+
+// pendingRow is a parsed row waiting for its batch to be written.
+type pendingRow struct {
+	row    *Row
+	values []interface{}
+}
+
+// ingestWorker parses rows and batches them for insertion. Every worker has
+// its own batch so that they can be inserted concurrently. The word stems
+// are built from the loaded table afterwards, as concurrent upserts of the
+// same stems could deadlock.
+type ingestWorker struct {
+	db        *Database
+	tableName string
+	reject    RejectFunc
+	pending   []*pendingRow
+}
+
+// IngestRows ingests the rows read from the channel into the specified
+// table. The rows are spread across a pool of workers which parse, convert
+// and insert them concurrently. Rejected rows are passed to the reject
+// function, including the rows of a batch the database refused because of
+// their values. The number of ingested rows is returned once the channel is
+// closed and all batches have been inserted.
+func (d *Database) IngestRows(tableName string, rows <-chan *Row, reject RejectFunc) (int, error) {
+	log.Infof("Ingesting rows into %s using %d workers", tableName, d.NumWorkers)
+
+	var lock sync.Mutex
+	var wg sync.WaitGroup
+	var ingestErr error
+	ingested := 0
+
+	fail := func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if ingestErr == nil {
+			ingestErr = err
+		}
+	}
+	failed := func() bool {
+		lock.Lock()
+		defer lock.Unlock()
+		return ingestErr != nil
+	}
+	count := func(n int) {
+		lock.Lock()
+		defer lock.Unlock()
+		ingested = ingested + n
+	}
+	rejectRow := func(row *Row, rowErr *RowError) error {
+		lock.Lock()
+		defer lock.Unlock()
+		return reject(row, rowErr)
+	}
+
+	for i := 0; i < d.NumWorkers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker := d.newIngestWorker(tableName, rejectRow)
+
+			// keep draining the input after a failure so the reader is not blocked.
+			for row := range rows {
+				if failed() {
+					continue
+				}
+
+				n, err := worker.ingest(row)
+				count(n)
+				if err != nil {
+					fail(err)
+				}
+			}
+
+			if failed() {
+				return
+			}
+			n, err := worker.flushRows()
+			count(n)
+			if err != nil {
+				fail(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return ingested, ingestErr
+}
+
+// CountRows returns the number of rows stored in the base table of a dataset.
+func (d *Database) CountRows(tableName string) (int, error) {
+	var count int
+	_, err := d.DB.QueryOne(pg.Scan(&count), fmt.Sprintf("SELECT COUNT(*) FROM %s_base;", tableName))
+	if err != nil {
+		return 0, errors.Wrapf(err, "unable to count rows of %s", tableName)
+	}
+
+	return count, nil
+}
+
+func (d *Database) newIngestWorker(tableName string, reject RejectFunc) *ingestWorker {
+	return &ingestWorker{
+		db:        d,
+		tableName: tableName,
+		reject:    reject,
+		pending:   make([]*pendingRow, 0, d.BatchSize),
+	}
+}
+
+// ingest parses the row and adds it to the batch, writing the batch once
+// full. It returns the number of rows written.
+func (w *ingestWorker) ingest(row *Row) (int, error) {
+	values, err := w.db.parseRow(w.tableName, row.Data)
+	if err != nil {
+		rowErr, ok := err.(*RowError)
+		if !ok {
+			return 0, err
+		}
+		return 0, w.reject(row, rowErr)
+	}
+	w.pending = append(w.pending, &pendingRow{
+		row:    row,
+		values: values,
+	})
+
+	if len(w.pending) < w.db.BatchSize {
+		return 0, nil
+	}
+
+	return w.flushRows()
+}
+
+// flushRows writes the pending rows. When the database refuses the batch
+// because of the values it holds, the rows are written one at a time so that
+// only the offending rows are rejected.
+func (w *ingestWorker) flushRows() (int, error) {
+	if len(w.pending) == 0 {
+		return 0, nil
+	}
+	pending := w.pending
+	w.pending = make([]*pendingRow, 0, w.db.BatchSize)
+
+	batch := make([][]interface{}, len(pending))
+	for i, p := range pending {
+		batch[i] = p.values
+	}
+	err := w.db.writeRows(w.tableName, batch)
+	if err == nil {
+		return len(pending), nil
+	}
+	if !isDataError(err) {
+		return 0, err
+	}
+	log.Warnf("Unable to write a batch of %d rows into %s, writing the rows one at a time: %v", len(pending), w.tableName, err)
+
+	written := 0
+	for _, p := range pending {
+		err = w.db.insertRows(w.tableName, [][]interface{}{p.values})
+		if err == nil {
+			written = written + 1
+			continue
+		}
+		if !isDataError(err) {
+			return written, err
+		}
+		err = w.reject(p.row, &RowError{Reason: errors.Cause(err).Error()})
+		if err != nil {
+			return written, err
+		}
+	}
+
+	return written, nil
+}
+
+// writeRows writes a batch of rows into the base table using the loader of
+// the database.
+func (d *Database) writeRows(tableName string, rows [][]interface{}) error {
+	if d.Loader == CopyLoader {
+		return d.copyRows(tableName, rows)
+	}
+	return d.insertRows(tableName, rows)
+}
+
+// insertRows inserts the rows into the base table in a single statement.
+func (d *Database) insertRows(tableName string, rows [][]interface{}) error {
+	ds := model.NewDataset(tableName, tableName, "", nil)
+	for _, values := range rows {
+		insertStatement := strings.Repeat(", ?", len(values))
+		ds.AddInsert(fmt.Sprintf("(%s)", insertStatement[2:]), values)
+	}
+
+	err := d.executeInserts(tableName, ds)
+	if err != nil {
+		return errors.Wrap(err, "unable to insert to table "+tableName)
+	}
+
+	return nil
+}
+
+// isDataError indicates whether or not the database refused a statement
+// because of the data it holds, as opposed to a failure of the database.
+func isDataError(err error) bool {
+	pgErr, ok := errors.Cause(err).(pg.Error)
+	if !ok {
+		return false
+	}
+	// data exceptions and integrity constraint violations.
+	code := pgErr.Field('C')
+	return strings.HasPrefix(code, "22") || strings.HasPrefix(code, "23")
+}
@@ -16,15 +16,19 @@
 package postgres
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"regexp"
+	"io"
 	"strings"
+	"sync"
 
 	"github.com/go-pg/pg"
 	"github.com/pkg/errors"
 
 	api "github.com/uncharted-distil/distil-compute/model"
 	"github.com/uncharted-distil/distil-ingest/conf"
+	"github.com/uncharted-distil/distil-ingest/metadata"
 	"github.com/uncharted-distil/distil-ingest/postgres/model"
 	"github.com/unchartedsoftware/deluge/document"
 	"github.com/unchartedsoftware/plog"
@@ -34,7 +38,8 @@ const (
 	metadataTableCreationSQL = `CREATE TABLE %s (
 			name	varchar(100)	NOT NULL,
 			role	varchar(100),
-			type	varchar(100)
+			type	varchar(100),
+			stats	JSONB
 		);`
 	resultTableCreationSQL = `CREATE TABLE %s (
 			result_id	varchar(1000)	NOT NULL,
@@ -113,14 +118,43 @@ var (
 		"float":   true,
 		"real":    true,
 	}
-	wordRegex = regexp.MustCompile("[^a-zA-Z]")
 )
 
 // Database is a struct representing a full logical database.
 type Database struct {
-	DB        *pg.DB
-	Tables    map[string]*model.Dataset
-	BatchSize int
+	DB         *pg.DB
+	Tables     map[string]*model.Dataset
+	BatchSize  int
+	NumWorkers int
+	Loader     string
+
+	// TypedStorage stores the variables using their postgres types in the
+	// base table, rather than as text cast by the view.
+	TypedStorage   bool
+	castErrors     map[string][]*castError
+	castErrorsLock sync.Mutex
+
+	// IndexPolicy selects the indexes created once a dataset is loaded.
+	IndexPolicy *IndexPolicy
+
+	// Search selects the generated tsvector columns added to the base table.
+	Search string
+
+	// Geography stores the latitude and longitude pairs as PostGIS points.
+	Geography bool
+
+	wordStemTable string
+}
+
+// RowError is returned when a single row of input could not be mapped to the
+// dataset variables. The row is skipped and the rest of the ingest is
+// unaffected.
+type RowError struct {
+	Reason string
+}
+
+func (e *RowError) Error() string {
+	return e.Reason
 }
 
 // WordStem contains the pairing of a word and its stemmed version.
@@ -131,20 +165,59 @@ type WordStem struct {
 
 // NewDatabase creates a new database instance.
 func NewDatabase(config *conf.Conf) (*Database, error) {
+	loader := config.DBLoader
+	if loader == "" {
+		loader = InsertLoader
+	}
+	if loader != InsertLoader && loader != CopyLoader {
+		return nil, errors.Errorf("unrecognized database loader '%s'", loader)
+	}
+
+	indexPolicy, err := ParseIndexPolicy(config.DBIndexes)
+	if err != nil {
+		return nil, err
+	}
+
+	search, err := validateSearchMode(config.DBSearch)
+	if err != nil {
+		return nil, err
+	}
+
+	numWorkers := config.NumWorkers
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+	poolSize := config.NumActiveConnections
+	if poolSize > 0 && poolSize < numWorkers {
+		// every worker writes its batches on a pooled connection, with an
+		// INSERT or a COPY per batch, so the pool holds at least one each.
+		poolSize = numWorkers
+	}
+
 	db := pg.Connect(&pg.Options{
 		Addr:     fmt.Sprintf("%s:%d", config.DBHost, config.DBPort),
 		User:     config.DBUser,
 		Password: config.DBPassword,
 		Database: config.Database,
+		PoolSize: poolSize,
 	})
 
 	database := &Database{
-		DB:        db,
-		Tables:    make(map[string]*model.Dataset),
-		BatchSize: config.DBBatchSize,
-	}
+		DB:         db,
+		Tables:     make(map[string]*model.Dataset),
+		BatchSize:  config.DBBatchSize,
+		NumWorkers: numWorkers,
+		Loader:     loader,
+
+		TypedStorage: config.DBTypedStorage,
+		castErrors:   make(map[string][]*castError),
 
-	database.Tables[wordStemTableName] = model.NewDataset(wordStemTableName, wordStemTableName, "", nil)
+		IndexPolicy: indexPolicy,
+		Search:      search,
+		Geography:   config.DBGeography,
+
+		wordStemTable: wordStemTableName,
+	}
 
 	return database, nil
 }
@@ -198,9 +271,7 @@ func (d *Database) CreateSolutionMetadataTables() error {
 	return nil
 }
 
-func (d *Database) executeInserts(tableName string) error {
-	ds := d.Tables[tableName]
-
+func (d *Database) executeInserts(tableName string, ds *model.Dataset) error {
 	insertStatement := fmt.Sprintf("INSERT INTO %s.%s.%s_base VALUES %s;", "distil", "public", tableName, strings.Join(ds.GetBatch(), ", "))
 
 	_, err := d.DB.Exec(insertStatement, ds.GetBatchArgs()...)
@@ -208,12 +279,114 @@ func (d *Database) executeInserts(tableName string) error {
 	return err
 }
 
-func (d *Database) executeInsertsComplete(tableName string) error {
-	ds := d.Tables[tableName]
+// StoreProfile stores the statistics of the profiled variables in the
+// variable table of the dataset, and the statistics of the dataset as the
+// comment of that table so that they follow it when the dataset is renamed
+// or replaced. Nothing is stored without a profile.
+func (d *Database) StoreProfile(tableName string, profile *metadata.DatasetProfile) error {
+	if profile == nil {
+		return nil
+	}
+	variableTableName := fmt.Sprintf("%s%s", tableName, variableTableSuffix)
 
-	_, err := d.DB.Exec(strings.Join(ds.GetBatch(), " "), ds.GetBatchArgs()...)
+	if profile.Dataset != nil {
+		encoded, err := json.Marshal(profile.Dataset)
+		if err != nil {
+			return errors.Wrapf(err, "unable to encode stats of dataset %s", tableName)
+		}
+		_, err = d.DB.Exec(fmt.Sprintf("COMMENT ON TABLE %s IS ?;", variableTableName), string(encoded))
+		if err != nil {
+			return errors.Wrapf(err, "unable to store stats of dataset %s", tableName)
+		}
+	}
+	updateStatement := fmt.Sprintf("UPDATE %s SET stats = ? WHERE name = ?;", variableTableName)
 
-	return err
+	for name, stats := range profile.Variables {
+		encoded, err := json.Marshal(stats)
+		if err != nil {
+			return errors.Wrapf(err, "unable to encode stats of variable %s", name)
+		}
+		_, err = d.DB.Exec(updateStatement, string(encoded), name)
+		if err != nil {
+			return errors.Wrapf(err, "unable to store stats of variable %s", name)
+		}
+	}
+
+	return nil
+}
+
+// FetchDatasetStats reads the statistics stored for a dataset, which are nil
+// for datasets ingested without them.
+func (d *Database) FetchDatasetStats(name string) (*metadata.DatasetStats, error) {
+	var comment string
+	_, err := d.DB.QueryOne(pg.Scan(&comment), "SELECT COALESCE(obj_description(CAST(? AS regclass), 'pg_class'), '');",
+		fmt.Sprintf("%s%s", name, variableTableSuffix))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read stats of dataset %s", name)
+	}
+	if comment == "" {
+		return nil, nil
+	}
+
+	stats := &metadata.DatasetStats{}
+	err = json.Unmarshal([]byte(comment), stats)
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to parse stats of dataset %s", name)
+	}
+
+	return stats, nil
+}
+
+// ProfileDataset computes the statistics of the variables from the rows
+// stored in the base table of a dataset, in a single pass, so that they
+// cover every appended row. The dataset statistics only hold the row and
+// column counts, the stored rows being well formed.
+func (d *Database) ProfileDataset(tableName string, variables []*api.Variable) (*metadata.DatasetProfile, error) {
+	columns := make([]string, len(variables))
+	for i, v := range variables {
+		columns[i] = fmt.Sprintf("CAST(\"%s\" AS TEXT)", v.Name)
+	}
+	profiler := metadata.NewDatasetProfiler(variables)
+
+	// the rows are parsed as they are copied out of the table.
+	reader, writer := io.Pipe()
+	parsed := make(chan error, 1)
+	go func() {
+		records := csv.NewReader(reader)
+		for {
+			record, err := records.Read()
+			if err == io.EOF {
+				parsed <- nil
+				return
+			}
+			if err != nil {
+				// fails the copy rather than leaving it blocked.
+				reader.CloseWithError(err)
+				parsed <- err
+				return
+			}
+			profiler.Add(record)
+		}
+	}()
+
+	log.Infof("Profiling the rows of %s_base", tableName)
+	_, err := d.DB.CopyTo(writer, fmt.Sprintf("COPY (SELECT %s FROM %s_base) TO STDOUT WITH CSV;", strings.Join(columns, ", "), tableName))
+	writer.CloseWithError(err)
+	parseErr := <-parsed
+	if err == nil {
+		err = parseErr
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to profile the rows of dataset %s", tableName)
+	}
+
+	profile := profiler.Profile()
+	profile.Dataset = &metadata.DatasetStats{
+		NumRows:    profiler.RowCount(),
+		NumColumns: int64(len(variables)),
+	}
+
+	return profile, nil
 }
 
 // CreateResultTable creates an empty table for the solution results.
@@ -266,33 +439,51 @@ func (d *Database) StoreMetadata(tableName string) error {
 	return nil
 }
 
-// DeleteDataset deletes all tables & views for a dataset.
-func (d *Database) DeleteDataset(name string) {
-	baseName := fmt.Sprintf("%s_base", name)
-	resultName := fmt.Sprintf("%s%s", name, resultTableSuffix)
-	variableName := fmt.Sprintf("%s%s", name, variableTableSuffix)
+// DeleteDataset deletes all tables & views for a dataset. Every table is
+// dropped even if one fails, and the first error is returned. Tables which
+// do not exist are skipped.
+func (d *Database) DeleteDataset(name string) error {
+	statements := []string{
+		fmt.Sprintf("DROP VIEW IF EXISTS %s;", name),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s_base;", name),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s%s;", name, resultTableSuffix),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s%s;", name, variableTableSuffix),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s%s;", name, castErrorTableSuffix),
+	}
+
+	var deleteErr error
+	for _, statement := range statements {
+		log.Infof("Deleting dataset %s: %s", name, statement)
+		_, err := d.DB.Exec(statement)
+		if err != nil && deleteErr == nil {
+			deleteErr = errors.Wrapf(err, "unable to delete dataset %s", name)
+		}
+	}
 
-	d.DropView(name)
-	d.DropTable(baseName)
-	d.DropTable(resultName)
-	d.DropTable(variableName)
+	return deleteErr
 }
 
-// IngestRow parses the raw csv data and stores it to the table specified.
-// The previously parsed metadata is used to map columns.
-func (d *Database) IngestRow(tableName string, data string) error {
+// parseRow splits the raw csv data into the values to store for each
+// variable of the dataset, mapping empty values and arrays as needed. When
+// using typed storage, values are converted to the column types and those
+// that cannot be converted are stored as null and recorded as cast errors.
+func (d *Database) parseRow(tableName string, data string) ([]interface{}, error) {
 	ds := d.Tables[tableName]
-
-	insertStatement := ""
 	variables := ds.Variables
 	values := make([]interface{}, len(variables))
 	doc := &document.CSV{}
-	doc.SetData(data)
+	err := doc.SetData(data)
+	if err != nil {
+		return nil, &RowError{Reason: fmt.Sprintf("unable to parse row: %v", err)}
+	}
 
 	// If a row ends in a delimeter, deluge does not add the last field.
 	if len(doc.Cols) == len(variables)-1 && strings.HasSuffix(data, ",") {
 		doc.Cols = append(doc.Cols, "")
 	}
+	if len(doc.Cols) != len(variables) {
+		return nil, &RowError{Reason: fmt.Sprintf("expected %d columns but found %d", len(variables), len(doc.Cols))}
+	}
 	for i := 0; i < len(variables); i++ {
 		// Default columns that have an empty column.
 		var val interface{}
@@ -303,73 +494,28 @@ func (d *Database) IngestRow(tableName string, data string) error {
 		} else {
 			val = doc.Cols[i]
 		}
-		insertStatement = fmt.Sprintf("%s, ?", insertStatement)
 		values[i] = val
 	}
-	insertStatement = fmt.Sprintf("(%s)", insertStatement[2:])
-	ds.AddInsert(insertStatement, values)
 
-	if ds.GetBatchSize() >= d.BatchSize {
-		err := d.executeInserts(tableName)
+	if d.TypedStorage {
+		err = d.convertRow(tableName, variables, values)
 		if err != nil {
-			return errors.Wrap(err, "unable to insert to table "+tableName)
+			return nil, err
 		}
-
-		ds.ResetBatch()
 	}
 
-	return nil
+	return values, nil
 }
 
-// InsertRemainingRows empties all batches and inserts the data to the database.
+// InsertRemainingRows inserts the cast errors still pending once the rows
+// are ingested.
 func (d *Database) InsertRemainingRows() error {
-	for tableName, ds := range d.Tables {
-		if ds.GetBatchSize() > 0 {
-			if tableName != wordStemTableName {
-				err := d.executeInserts(tableName)
-				if err != nil {
-					return errors.Wrap(err, "unable to insert remaining rows for table "+tableName)
-				}
-			} else {
-				err := d.executeInsertsComplete(tableName)
-				if err != nil {
-					return errors.Wrap(err, "unable to insert remaining rows for table "+tableName)
-				}
-			}
-		}
-	}
-
-	return nil
-}
-
-// AddWordStems builds the word stemming lookup in the database.
-func (d *Database) AddWordStems(data string) error {
-	ds := d.Tables[wordStemTableName]
-
-	// process every field (assume csv).
-	doc := &document.CSV{}
-	doc.SetData(data)
-
-	for i := 0; i < len(doc.Cols); i++ {
-		// split the field into tokens.
-		fields := strings.Fields(doc.Cols[i])
-		for _, f := range fields {
-			fieldValue := wordRegex.ReplaceAllString(f, "")
-			if fieldValue == "" {
-				continue
-			}
-
-			// query for the stemmed version of each word.
-			query := fmt.Sprintf("INSERT INTO %s VALUES (unnest(tsvector_to_array(to_tsvector(?))), ?) ON CONFLICT (stem) DO NOTHING;", wordStemTableName)
-			ds.AddInsert(query, []interface{}{fieldValue, strings.ToLower(fieldValue)})
-			if ds.GetBatchSize() >= d.BatchSize {
-				err := d.executeInsertsComplete(wordStemTableName)
-				if err != nil {
-					return errors.Wrap(err, "unable to insert to table "+wordStemTableName)
-				}
-
-				ds.ResetBatch()
-			}
+	d.castErrorsLock.Lock()
+	defer d.castErrorsLock.Unlock()
+	for tableName := range d.castErrors {
+		err := d.insertCastErrors(tableName)
+		if err != nil {
+			return err
 		}
 	}
 
@@ -398,45 +544,96 @@ func (d *Database) DropView(viewName string) error {
 
 // InitializeTable generates and runs a table create statement based on the schema.
 func (d *Database) InitializeTable(tableName string, ds *model.Dataset) error {
+	err := d.checkGeneratedColumnSupport(ds.Variables)
+	if err != nil {
+		return err
+	}
+	err = d.enableGeography(ds.Variables)
+	if err != nil {
+		return err
+	}
 	d.Tables[tableName] = ds
 
 	// Create the view and table statements.
-	// The table has everything stored as a string.
-	// The view uses casting to set the types.
+	// The table has everything stored as a string unless typed storage is
+	// used. The view uses casting to set the types.
 	createStatementTable := `CREATE TABLE %s_base (%s);`
-	createStatementView := `CREATE VIEW %s AS SELECT %s FROM %s_base;`
 	varsTable := ""
-	varsView := ""
 	for _, variable := range ds.Variables {
-		varsTable = fmt.Sprintf("%s\n\"%s\" TEXT,", varsTable, variable.Name)
-		varsView = fmt.Sprintf("%s\nCOALESCE(CAST(\"%s\" AS %s), %v) AS \"%s\",",
-			varsView, variable.Name, api.MapD3MTypeToPostgresType(variable.Type), api.DefaultPostgresValueFromD3MType(variable.Type), variable.Name)
+		varsTable = fmt.Sprintf("%s\n\"%s\" %s,", varsTable, variable.Name, baseColumnType(variable, d.TypedStorage))
+	}
+	for _, column := range append(d.searchColumns(ds.Variables), d.geographyColumns(ds.Variables)...) {
+		varsTable = fmt.Sprintf("%s\n\"%s\" %s,", varsTable, column.name, column.definition)
 	}
 	if len(varsTable) > 0 {
 		varsTable = varsTable[:len(varsTable)-1]
-		varsView = varsView[:len(varsView)-1]
 	}
 	createStatementTable = fmt.Sprintf(createStatementTable, tableName, varsTable)
 	log.Infof("Creating table %s_base", tableName)
 
 	// Create the table.
-	_, err := d.DB.Exec(createStatementTable)
+	_, err = d.DB.Exec(createStatementTable)
 	if err != nil {
 		return err
 	}
 
-	createStatementView = fmt.Sprintf(createStatementView, tableName, varsView, tableName)
 	log.Infof("Creating view %s", tableName)
 
-	// Create the table.
-	_, err = d.DB.Exec(createStatementView)
+	// The storage mode is kept with the table so that the variables added
+	// later are stored and read the same way.
+	err = d.storeTableSetting(tableName, storageSetting, storageMode(d.TypedStorage))
 	if err != nil {
 		return err
 	}
 
+	// Create the view.
+	_, err = d.DB.Exec(d.createViewStatement(tableName, ds.Variables, d.TypedStorage))
+	if err != nil {
+		return err
+	}
+
+	if d.TypedStorage {
+		castErrorTableName := fmt.Sprintf("%s%s", tableName, castErrorTableSuffix)
+		log.Infof("Creating cast error table %s", castErrorTableName)
+		_, err = d.DB.Exec(fmt.Sprintf(castErrorTableCreationSQL, castErrorTableName))
+		if err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+// createViewStatement builds the statement creating the view which casts
+// the base table columns to the variable types. Typed base columns need no
+// cast, so only the defaults are applied. The search and geography columns
+// are exposed as they are.
+func (d *Database) createViewStatement(tableName string, variables []*api.Variable, typed bool) string {
+	varsView := ""
+	for _, variable := range variables {
+		varsView = fmt.Sprintf("%s\n%s AS \"%s\",", varsView, viewColumnExpression(variable, typed), variable.Name)
+	}
+	for _, column := range append(d.searchColumns(variables), d.geographyColumns(variables)...) {
+		varsView = fmt.Sprintf("%s\n\"%s\",", varsView, column.name)
+	}
+	if len(varsView) > 0 {
+		varsView = varsView[:len(varsView)-1]
+	}
+
+	return fmt.Sprintf("CREATE VIEW %s AS SELECT %s FROM %s_base;", tableName, varsView, tableName)
+}
+
+// viewColumnExpression returns the expression the view uses to read the
+// variable from the base table, stored typed or as text.
+func viewColumnExpression(variable *api.Variable, typed bool) string {
+	if typed {
+		return fmt.Sprintf("COALESCE(\"%s\", %v)",
+			variable.Name, api.DefaultPostgresValueFromD3MType(variable.Type))
+	}
+	return fmt.Sprintf("COALESCE(CAST(\"%s\" AS %s), %v)",
+		variable.Name, api.MapD3MTypeToPostgresType(variable.Type), api.DefaultPostgresValueFromD3MType(variable.Type))
+}
+
 // InitializeDataset initializes the dataset with the provided metadata.
 func (d *Database) InitializeDataset(meta *api.Metadata) (*model.Dataset, error) {
 	ds := model.NewDataset(meta.ID, meta.Name, meta.Description, meta)
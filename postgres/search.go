@@ -0,0 +1,182 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-pg/pg"
+	"github.com/pkg/errors"
+
+	api "github.com/uncharted-distil/distil-compute/model"
+	"github.com/unchartedsoftware/plog"
+)
+
+const (
+	// SearchNone builds no search columns.
+	SearchNone = "none"
+	// SearchColumn adds a search column for every text variable.
+	SearchColumn = "column"
+	// SearchRow adds a single search column combining the text variables.
+	SearchRow = "row"
+
+	searchColumnPrefix = "_tsv"
+
+	// generatedColumnsVersion is the first server version supporting the
+	// generated columns holding the search vectors.
+	generatedColumnsVersion = 120000
+
+	// buildWordStemsSQL splits every value of the base table into words and
+	// stores the stem of each word not yet in the lookup.
+	buildWordStemsSQL = `INSERT INTO %s (stem, word)
+		SELECT DISTINCT ON (stem) stem, word FROM (
+			SELECT unnest(tsvector_to_array(to_tsvector(w))) AS stem, lower(w) AS word FROM (
+				SELECT regexp_replace(t, '[^a-zA-Z]', '', 'g') AS w FROM (%s) tokens
+			) words WHERE w <> ''
+		) stems
+		ON CONFLICT (stem) DO NOTHING;`
+	tokenSelectSQL = `SELECT regexp_split_to_table(CAST("%s" AS TEXT), '\s+') AS t FROM %s_base`
+)
+
+// generatedColumn is a column of the base table computed from the variable
+// columns, such as the search vectors.
+type generatedColumn struct {
+	name       string
+	definition string
+}
+
+func validateSearchMode(mode string) (string, error) {
+	switch mode {
+	case "":
+		return SearchNone, nil
+	case SearchNone, SearchColumn, SearchRow:
+		return mode, nil
+	}
+	return "", errors.Errorf("unrecognized search mode '%s'", mode)
+}
+
+// searchColumns lists the search columns to add to a base table holding the
+// variables.
+func (d *Database) searchColumns(variables []*api.Variable) []*generatedColumn {
+	if d.Search == SearchNone {
+		return nil
+	}
+
+	documents := make([]string, 0)
+	names := make([]string, 0)
+	for _, v := range variables {
+		if !textIndexTypes[v.Type] {
+			continue
+		}
+		documents = append(documents, fmt.Sprintf("COALESCE(\"%s\", '')", v.Name))
+		names = append(names, v.Name)
+	}
+	if len(documents) == 0 {
+		return nil
+	}
+
+	if d.Search == SearchRow {
+		return []*generatedColumn{newSearchColumn(searchColumnPrefix, strings.Join(documents, " || ' ' || "))}
+	}
+
+	columns := make([]*generatedColumn, len(documents))
+	for i, document := range documents {
+		columns[i] = newSearchColumn(fmt.Sprintf("%s_%s", searchColumnPrefix, names[i]), document)
+	}
+
+	return columns
+}
+
+func newSearchColumn(name string, document string) *generatedColumn {
+	return &generatedColumn{
+		name:       name,
+		definition: fmt.Sprintf("tsvector GENERATED ALWAYS AS (to_tsvector('english', %s)) STORED", document),
+	}
+}
+
+// checkGeneratedColumnSupport makes sure the server supports generated
+// columns when search or geography columns are added to the variables.
+func (d *Database) checkGeneratedColumnSupport(variables []*api.Variable) error {
+	if len(d.searchColumns(variables)) == 0 && len(d.geographyColumns(variables)) == 0 {
+		return nil
+	}
+
+	var version int
+	_, err := d.DB.QueryOne(pg.Scan(&version), "SELECT CAST(current_setting('server_version_num') AS integer);")
+	if err != nil {
+		return errors.Wrap(err, "unable to read the server version")
+	}
+	if version < generatedColumnsVersion {
+		return errors.Errorf("generated columns require PostgreSQL 12 or later but the server version is %d", version)
+	}
+
+	return nil
+}
+
+// searchColumnStatements returns the statements altering the base table of
+// a dataset to search the added variables as well as the existing ones. The
+// search columns missing from the existing table are added too, as the view
+// reads every one of them.
+func (d *Database) searchColumnStatements(tableName string, variables []*api.Variable, added []*api.Variable) []string {
+	statements := make([]string, 0)
+	if d.Search == SearchRow && len(d.searchColumns(added)) > 0 {
+		// the expression of a generated column cannot be altered.
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s_base DROP COLUMN IF EXISTS \"%s\";", tableName, searchColumnPrefix))
+	}
+	for _, c := range d.searchColumns(variables) {
+		statements = append(statements, fmt.Sprintf("ALTER TABLE %s_base ADD COLUMN IF NOT EXISTS \"%s\" %s;", tableName, c.name, c.definition))
+	}
+
+	return statements
+}
+
+// createSearchIndexes creates a GIN index on every search column of the base
+// table.
+func (d *Database) createSearchIndexes(tableName string, variables []*api.Variable) {
+	for _, c := range d.searchColumns(variables) {
+		err := d.createIndex(fmt.Sprintf("CREATE INDEX IF NOT EXISTS %s ON %s_base USING gin (\"%s\");",
+			indexName(tableName, c.name), tableName, c.name))
+		if err != nil {
+			log.Warnf("unable to create search index on %s: %v", c.name, err)
+		}
+	}
+}
+
+// BuildWordStems fills the word stem lookup from the values stored in the
+// base table of the dataset, in a single statement.
+func (d *Database) BuildWordStems(tableName string) error {
+	ds := d.Tables[tableName]
+	if ds == nil {
+		return errors.Errorf("dataset %s has not been initialized", tableName)
+	}
+	if len(ds.Variables) == 0 {
+		return nil
+	}
+
+	tokens := make([]string, len(ds.Variables))
+	for i, v := range ds.Variables {
+		tokens[i] = fmt.Sprintf(tokenSelectSQL, v.Name, tableName)
+	}
+
+	log.Infof("Building word stems of %s into %s", tableName, d.wordStemTable)
+	_, err := d.DB.Exec(fmt.Sprintf(buildWordStemsSQL, d.wordStemTable, strings.Join(tokens, " UNION ALL ")))
+	if err != nil {
+		return errors.Wrapf(err, "unable to build word stems of %s", tableName)
+	}
+
+	return nil
+}
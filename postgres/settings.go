@@ -0,0 +1,97 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-pg/pg"
+	"github.com/pkg/errors"
+)
+
+const (
+	// indexPolicySetting holds the index policy of a dataset.
+	indexPolicySetting = "indexes"
+	// storageSetting holds the storage mode a dataset was created with.
+	storageSetting = "storage"
+
+	typedStorageMode = "typed"
+	textStorageMode  = "text"
+)
+
+// fetchTableSettings reads the settings stored as the comment of the base
+// table of a dataset, so that they follow the table when it is renamed.
+func (d *Database) fetchTableSettings(name string) (map[string]string, error) {
+	var comment string
+	_, err := d.DB.QueryOne(pg.Scan(&comment), "SELECT COALESCE(obj_description(CAST(? AS regclass), 'pg_class'), '');",
+		fmt.Sprintf("%s_base", name))
+	if err != nil {
+		return nil, errors.Wrapf(err, "unable to read settings of dataset %s", name)
+	}
+
+	return parseTableSettings(comment), nil
+}
+
+// storeTableSetting stores a setting of a dataset, keeping the others.
+func (d *Database) storeTableSetting(name string, key string, value string) error {
+	settings, err := d.fetchTableSettings(name)
+	if err != nil {
+		return err
+	}
+	settings[key] = value
+
+	_, err = d.DB.Exec(fmt.Sprintf("COMMENT ON TABLE %s_base IS ?;", name), formatTableSettings(settings))
+	if err != nil {
+		return errors.Wrapf(err, "unable to store %s setting of dataset %s", key, name)
+	}
+
+	return nil
+}
+
+// parseTableSettings parses the `key:value` pairs of a table comment, which
+// are separated by semicolons. A comment holding only the index policy, as
+// stored by the earlier versions, is a single pair.
+func parseTableSettings(comment string) map[string]string {
+	settings := make(map[string]string)
+	for _, pair := range strings.Split(comment, ";") {
+		parts := strings.SplitN(pair, ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		settings[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+	}
+
+	return settings
+}
+
+// formatTableSettings writes the settings as a table comment, sorted by key
+// so that the comment is stable.
+func formatTableSettings(settings map[string]string) string {
+	keys := make([]string, 0, len(settings))
+	for key := range settings {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = fmt.Sprintf("%s:%s", key, settings[key])
+	}
+
+	return strings.Join(pairs, ";")
+}
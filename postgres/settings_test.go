@@ -0,0 +1,36 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTableSettings(t *testing.T) {
+	// the comment of the earlier versions only holds the index policy.
+	settings := parseTableSettings("indexes:key,text")
+	assert.Equal(t, map[string]string{indexPolicySetting: "key,text"}, settings)
+
+	settings[storageSetting] = storageMode(true)
+	comment := formatTableSettings(settings)
+	assert.Equal(t, "indexes:key,text;storage:typed", comment)
+	assert.Equal(t, settings, parseTableSettings(comment))
+
+	assert.Empty(t, parseTableSettings(""))
+	assert.Empty(t, parseTableSettings("not a setting"))
+}
@@ -0,0 +1,135 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+
+	"github.com/go-pg/pg"
+	"github.com/pkg/errors"
+	"github.com/unchartedsoftware/plog"
+)
+
+const (
+	stagingSuffix = "_staging"
+
+	stagedWordStemsTableCreationSQL = `CREATE TABLE %s (LIKE %s INCLUDING ALL);`
+)
+
+// StagingName returns the name under which a dataset is loaded before being
+// promoted to its final name.
+func StagingName(name string) string {
+	return fmt.Sprintf("%s%s", name, stagingSuffix)
+}
+
+// DatasetExists checks if the base table of a dataset is present in the
+// current schema.
+func (d *Database) DatasetExists(name string) (bool, error) {
+	var exists bool
+	_, err := d.DB.QueryOne(pg.Scan(&exists),
+		"SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_schema = current_schema() AND table_name = ?);",
+		fmt.Sprintf("%s_base", name))
+	if err != nil {
+		return false, errors.Wrapf(err, "unable to check existence of dataset %s", name)
+	}
+
+	return exists, nil
+}
+
+// BeginStaging prepares the staging tables for a dataset. Anything left over
+// from a previously failed load is removed, and word stems are collected in
+// a staging table until the dataset is promoted.
+func (d *Database) BeginStaging(name string) (string, error) {
+	stagingName := StagingName(name)
+
+	d.DiscardStaging(stagingName)
+
+	stemTableName := fmt.Sprintf("%s_%s", stagingName, wordStemTableName)
+	log.Infof("Creating staged word stem table %s", stemTableName)
+	_, err := d.DB.Exec(fmt.Sprintf(stagedWordStemsTableCreationSQL, stemTableName, wordStemTableName))
+	if err != nil {
+		return "", errors.Wrap(err, "unable to create staged word stem table")
+	}
+	d.wordStemTable = stemTableName
+
+	return stagingName, nil
+}
+
+// DiscardStaging removes all staging tables for a dataset, leaving the
+// previously promoted version untouched.
+func (d *Database) DiscardStaging(stagingName string) {
+	err := d.DeleteDataset(stagingName)
+	if err != nil {
+		log.Warnf("%v", err)
+	}
+	d.DropTable(fmt.Sprintf("%s_%s", stagingName, wordStemTableName))
+	delete(d.Tables, stagingName)
+	d.wordStemTable = wordStemTableName
+}
+
+// PromoteDataset replaces the tables, views & indexes of a dataset with the
+// staged ones in a single transaction. If the transaction fails, the previous
+// version of the dataset is left intact.
+func (d *Database) PromoteDataset(stagingName string, name string) error {
+	log.Infof("Promoting dataset %s to %s", stagingName, name)
+
+	indexStatements, err := d.indexRenameStatements(stagingName, name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := d.DB.Begin()
+	if err != nil {
+		return errors.Wrap(err, "unable to start promotion transaction")
+	}
+
+	stemTableName := fmt.Sprintf("%s_%s", stagingName, wordStemTableName)
+	statements := []string{
+		fmt.Sprintf("DROP VIEW IF EXISTS %s;", name),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s_base;", name),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s%s;", name, resultTableSuffix),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s%s;", name, variableTableSuffix),
+		fmt.Sprintf("DROP TABLE IF EXISTS %s%s;", name, castErrorTableSuffix),
+		fmt.Sprintf("ALTER TABLE %s_base RENAME TO %s_base;", stagingName, name),
+		fmt.Sprintf("ALTER VIEW %s RENAME TO %s;", stagingName, name),
+		fmt.Sprintf("ALTER TABLE %s%s RENAME TO %s%s;", stagingName, resultTableSuffix, name, resultTableSuffix),
+		fmt.Sprintf("ALTER TABLE %s%s RENAME TO %s%s;", stagingName, variableTableSuffix, name, variableTableSuffix),
+		fmt.Sprintf("ALTER TABLE IF EXISTS %s%s RENAME TO %s%s;", stagingName, castErrorTableSuffix, name, castErrorTableSuffix),
+		fmt.Sprintf("INSERT INTO %s SELECT * FROM %s ON CONFLICT (stem) DO NOTHING;", wordStemTableName, stemTableName),
+		fmt.Sprintf("DROP TABLE %s;", stemTableName),
+	}
+	statements = append(statements, indexStatements...)
+	for _, statement := range statements {
+		_, err = tx.Exec(statement)
+		if err != nil {
+			tx.Rollback()
+			return errors.Wrapf(err, "unable to promote dataset %s", name)
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return errors.Wrapf(err, "unable to commit promotion of dataset %s", name)
+	}
+
+	if ds, ok := d.Tables[stagingName]; ok {
+		d.Tables[name] = ds
+		delete(d.Tables, stagingName)
+	}
+	d.wordStemTable = wordStemTableName
+
+	return nil
+}
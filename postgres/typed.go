@@ -0,0 +1,269 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+
+	api "github.com/uncharted-distil/distil-compute/model"
+)
+
+const (
+	castErrorTableSuffix = "_cast_error"
+
+	timestampLayout = "2006-01-02 15:04:05.999999999Z07:00"
+	dateLayout      = "2006-01-02"
+
+	castErrorTableCreationSQL = `CREATE TABLE %s (
+			"d3mIndex"	TEXT,
+			variable	varchar(100),
+			value		TEXT,
+			reason		TEXT
+		);`
+)
+
+var (
+	// timestampLayouts are the layouts accepted for timestamp and date
+	// columns, which are written back in the ISO format.
+	timestampLayouts = []string{
+		time.RFC3339Nano,
+		"2006-01-02T15:04:05",
+		"2006-01-02T15:04",
+		"2006-01-02 15:04:05Z07:00",
+		"2006-01-02 15:04:05",
+		"2006-01-02 15:04",
+		"2006-01-02",
+		"2006/01/02 15:04:05",
+		"2006/01/02",
+	}
+)
+
+// castError is a value which could not be converted to the type of its
+// variable when using typed storage.
+type castError struct {
+	index    string
+	variable string
+	value    string
+	reason   string
+}
+
+// FetchTypedStorage reads whether or not a dataset was created with typed
+// storage. The storage mode of the database is returned for datasets
+// ingested without one.
+func (d *Database) FetchTypedStorage(name string) (bool, error) {
+	settings, err := d.fetchTableSettings(name)
+	if err != nil {
+		return false, err
+	}
+	mode, ok := settings[storageSetting]
+	if !ok {
+		return d.TypedStorage, nil
+	}
+
+	return mode == typedStorageMode, nil
+}
+
+// storageMode names the storage mode stored with a dataset.
+func storageMode(typed bool) string {
+	if typed {
+		return typedStorageMode
+	}
+	return textStorageMode
+}
+
+// baseColumnType returns the type of the base table column storing the
+// variable, typed or as text.
+func baseColumnType(variable *api.Variable, typed bool) string {
+	if typed {
+		return api.MapD3MTypeToPostgresType(variable.Type)
+	}
+	return "TEXT"
+}
+
+// convertValue converts a raw value to the type of the base table column,
+// returning an error if the value cannot be represented in that type. Values
+// of the column types not handled here are refused.
+func convertValue(postgresType string, value string) (interface{}, error) {
+	typ := strings.ToUpper(strings.TrimSpace(postgresType))
+
+	if strings.HasSuffix(typ, "[]") {
+		elementType := strings.TrimSuffix(typ, "[]")
+		inner := strings.TrimSpace(strings.Trim(value, "{}"))
+		if inner == "" {
+			return "{}", nil
+		}
+		elements := strings.Split(inner, ",")
+		converted := make([]string, len(elements))
+		for i, e := range elements {
+			c, err := convertValue(elementType, e)
+			if err != nil {
+				return nil, err
+			}
+			converted[i] = fmt.Sprintf("%v", c)
+		}
+		return fmt.Sprintf("{%s}", strings.Join(converted, ",")), nil
+	}
+
+	trimmed := strings.TrimSpace(value)
+	switch typ {
+	case "INTEGER", "INT", "INT4", "INT8", "BIGINT", "SMALLINT":
+		i, err := strconv.ParseInt(trimmed, 10, 64)
+		if err == nil {
+			return i, nil
+		}
+		// accept integral values written as floats
+		f, ferr := strconv.ParseFloat(trimmed, 64)
+		if ferr != nil || f != math.Trunc(f) {
+			return nil, errors.Errorf("'%s' is not an integer", trimmed)
+		}
+		return int64(f), nil
+	case "FLOAT", "FLOAT4", "FLOAT8", "REAL", "DOUBLE PRECISION", "NUMERIC":
+		f, err := strconv.ParseFloat(trimmed, 64)
+		if err != nil {
+			return nil, errors.Errorf("'%s' is not a number", trimmed)
+		}
+		return f, nil
+	case "BOOLEAN", "BOOL":
+		b, err := strconv.ParseBool(trimmed)
+		if err != nil {
+			return nil, errors.Errorf("'%s' is not a boolean", trimmed)
+		}
+		return b, nil
+	case "TIMESTAMP", "TIMESTAMPTZ", "TIMESTAMP WITHOUT TIME ZONE", "TIMESTAMP WITH TIME ZONE":
+		t, err := parseTimestamp(trimmed)
+		if err != nil {
+			return nil, err
+		}
+		return t.Format(timestampLayout), nil
+	case "DATE":
+		t, err := parseTimestamp(trimmed)
+		if err != nil {
+			return nil, err
+		}
+		return t.Format(dateLayout), nil
+	case "TEXT", "VARCHAR", "CHARACTER VARYING":
+		return value, nil
+	default:
+		// the database would fail the whole batch on a value it cannot read.
+		return nil, errors.Errorf("unsupported column type %s", postgresType)
+	}
+}
+
+func parseTimestamp(value string) (time.Time, error) {
+	for _, layout := range timestampLayouts {
+		t, err := time.Parse(layout, value)
+		if err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, errors.Errorf("'%s' is not a timestamp", value)
+}
+
+// convertRow converts the parsed values of a row to the base table column
+// types, replacing values that cannot be converted by null.
+func (d *Database) convertRow(tableName string, variables []*api.Variable, values []interface{}) error {
+	index := ""
+	for i, v := range variables {
+		if v.Name == api.D3MIndexName && values[i] != nil {
+			index = values[i].(string)
+		}
+	}
+
+	for i, v := range variables {
+		if values[i] == nil {
+			continue
+		}
+		raw := values[i].(string)
+		if raw == "" && !d.isArray(v.Type) && strings.ToUpper(baseColumnType(v, d.TypedStorage)) != "TEXT" {
+			values[i] = nil
+			continue
+		}
+
+		converted, err := convertValue(baseColumnType(v, d.TypedStorage), raw)
+		if err != nil {
+			values[i] = nil
+			err = d.addCastError(tableName, &castError{
+				index:    index,
+				variable: v.Name,
+				value:    raw,
+				reason:   err.Error(),
+			})
+			if err != nil {
+				return err
+			}
+			continue
+		}
+		values[i] = converted
+	}
+
+	return nil
+}
+
+// mergeCastErrorStatements builds the statements adding the staged cast
+// errors to those of an existing dataset.
+func (d *Database) mergeCastErrorStatements(stagingName string, name string) []string {
+	if !d.TypedStorage {
+		return nil
+	}
+
+	return []string{
+		fmt.Sprintf("CREATE TABLE IF NOT EXISTS %s%s (LIKE %s%s);", name, castErrorTableSuffix, stagingName, castErrorTableSuffix),
+		fmt.Sprintf("INSERT INTO %s%s SELECT * FROM %s%s;", name, castErrorTableSuffix, stagingName, castErrorTableSuffix),
+	}
+}
+
+func (d *Database) addCastError(tableName string, castErr *castError) error {
+	d.castErrorsLock.Lock()
+	defer d.castErrorsLock.Unlock()
+
+	d.castErrors[tableName] = append(d.castErrors[tableName], castErr)
+	if len(d.castErrors[tableName]) >= d.BatchSize {
+		return d.insertCastErrors(tableName)
+	}
+
+	return nil
+}
+
+// insertCastErrors inserts the pending cast errors of the table. The cast
+// errors lock must be held by the caller.
+func (d *Database) insertCastErrors(tableName string) error {
+	castErrs := d.castErrors[tableName]
+	if len(castErrs) == 0 {
+		return nil
+	}
+
+	rows := make([]string, len(castErrs))
+	args := make([]interface{}, 0, len(castErrs)*4)
+	for i, c := range castErrs {
+		rows[i] = "(?, ?, ?, ?)"
+		args = append(args, c.index, c.variable, c.value, c.reason)
+	}
+
+	insertStatement := fmt.Sprintf("INSERT INTO %s%s VALUES %s;", tableName, castErrorTableSuffix, strings.Join(rows, ", "))
+	_, err := d.DB.Exec(insertStatement, args...)
+	if err != nil {
+		return errors.Wrap(err, "unable to insert to table "+tableName+castErrorTableSuffix)
+	}
+	delete(d.castErrors, tableName)
+
+	return nil
+}
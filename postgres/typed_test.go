@@ -0,0 +1,75 @@
+//
+//   Copyright © 2019 Uncharted Software Inc.
+//
+//   Licensed under the Apache License, Version 2.0 (the "License");
+//   you may not use this file except in compliance with the License.
+//   You may obtain a copy of the License at
+//
+//       http://www.apache.org/licenses/LICENSE-2.0
+//
+//   Unless required by applicable law or agreed to in writing, software
+//   distributed under the License is distributed on an "AS IS" BASIS,
+//   WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+//   See the License for the specific language governing permissions and
+//   limitations under the License.
+
+package postgres
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConvertValue(t *testing.T) {
+	val, err := convertValue("INTEGER", " 12 ")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), val)
+
+	val, err = convertValue("INTEGER", "12.0")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(12), val)
+
+	_, err = convertValue("INTEGER", "12.5")
+	assert.Error(t, err)
+
+	val, err = convertValue("double precision", "1.5")
+	assert.NoError(t, err)
+	assert.Equal(t, 1.5, val)
+
+	_, err = convertValue("FLOAT8", "abc")
+	assert.Error(t, err)
+
+	val, err = convertValue("BOOLEAN", "true")
+	assert.NoError(t, err)
+	assert.Equal(t, true, val)
+
+	val, err = convertValue("FLOAT8[]", "{1,2.5}")
+	assert.NoError(t, err)
+	assert.Equal(t, "{1,2.5}", val)
+
+	val, err = convertValue("FLOAT8[]", "{}")
+	assert.NoError(t, err)
+	assert.Equal(t, "{}", val)
+
+	_, err = convertValue("FLOAT8[]", "{1,x}")
+	assert.Error(t, err)
+
+	val, err = convertValue("TEXT", " some text ")
+	assert.NoError(t, err)
+	assert.Equal(t, " some text ", val)
+
+	val, err = convertValue("TIMESTAMP", "2019-03-08T10:15:00Z")
+	assert.NoError(t, err)
+	assert.Equal(t, "2019-03-08 10:15:00Z", val)
+
+	val, err = convertValue("DATE", "2019/03/08")
+	assert.NoError(t, err)
+	assert.Equal(t, "2019-03-08", val)
+
+	_, err = convertValue("TIMESTAMP", "not a date")
+	assert.Error(t, err)
+
+	_, err = convertValue("INTERVAL", "1 day")
+	assert.Error(t, err)
+}
@@ -52,7 +52,7 @@ func (s *IngestStep) Featurize(schemaFile string, dataset string,
 	os.Remove(outputSchemaPath)
 	os.Remove(outputDataPath)
 	// load metadata from original schema
-	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile)
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile, s.loadOptions...)
 	if err != nil {
 		return errors.Wrap(err, "unable to load original schema file")
 	}
@@ -124,7 +124,7 @@ func (s *IngestStep) Featurize(schemaFile string, dataset string,
 	mainDR.ResPath = relativePath
 
 	// write the new schema to file
-	err = metadata.WriteSchema(meta, outputSchemaPath)
+	err = s.writeSchema(meta, outputSchemaPath)
 	if err != nil {
 		return errors.Wrap(err, "unable to store feature schema")
 	}
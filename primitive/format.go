@@ -33,7 +33,7 @@ import (
 // Format will format a dataset to have the required structures for D3M.
 func (s *IngestStep) Format(schemaFile string, dataset string,
 	rootDataPath string, outputFolder string, hasHeader bool) error {
-	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile)
+	meta, err := metadata.LoadMetadataFromOriginalSchema(schemaFile, s.loadOptions...)
 	if err != nil {
 		return errors.Wrap(err, "unable to load original schema file")
 	}
@@ -128,7 +128,7 @@ func (s *IngestStep) addD3MIndex(schemaFile string, dataset string, meta *model.
 	dr.ResType = model.ResTypeTable
 
 	// write the new schema to file
-	err = metadata.WriteSchema(meta, outputSchemaPath)
+	err = s.writeSchema(meta, outputSchemaPath)
 	if err != nil {
 		return errors.Wrap(err, "unable to store feature schema")
 	}
@@ -84,10 +84,10 @@ func (s *IngestStep) GeocodeForwardUpdate(schemaFile string, classificationPath
 	fields := make(map[string][]*model.Variable)
 	for _, field := range geocodedData {
 		latName, lonName := getLatLonVariableNames(field[0].SourceField)
-		fields[field[0].SourceField] = []*model.Variable{
-			model.NewVariable(len(mainDR.Variables), latName, "label", latName, "string", "string", []string{"attribute"}, model.VarRoleMetadata, nil, mainDR.Variables, false),
-			model.NewVariable(len(mainDR.Variables)+1, lonName, "label", lonName, "string", "string", []string{"attribute"}, model.VarRoleMetadata, nil, mainDR.Variables, false),
-		}
+		latVar := model.NewVariable(len(mainDR.Variables), latName, "label", latName, model.LatitudeType, model.LatitudeType, []string{"attribute"}, model.VarRoleMetadata, nil, mainDR.Variables, false)
+		lonVar := model.NewVariable(len(mainDR.Variables)+1, lonName, "label", lonName, model.LongitudeType, model.LongitudeType, []string{"attribute"}, model.VarRoleMetadata, nil, mainDR.Variables, false)
+		metadata.MarkGeoPair(field[0].SourceField, latVar, lonVar)
+		fields[field[0].SourceField] = []*model.Variable{latVar, lonVar}
 		mainDR.Variables = append(mainDR.Variables, fields[field[0].SourceField]...)
 		for _, gc := range field {
 			if indexedData[gc.D3MIndex] == nil {
@@ -139,7 +139,7 @@ func (s *IngestStep) GeocodeForwardUpdate(schemaFile string, classificationPath
 	mainDR.ResPath = relativePath
 
 	// write the new schema to file
-	err = metadata.WriteSchema(meta, outputSchemaPath)
+	err = s.writeSchema(meta, outputSchemaPath)
 	if err != nil {
 		return errors.Wrap(err, "unable to store feature schema")
 	}
@@ -49,7 +49,7 @@ func (s *IngestStep) Merge(dataset string, outputFolder string) error {
 	os.Remove(outputDataPath)
 
 	// need to manually build the metadata and output it.
-	meta, err := metadata.LoadMetadataFromOriginalSchema(dataset)
+	meta, err := metadata.LoadMetadataFromOriginalSchema(dataset, s.loadOptions...)
 	if err != nil {
 		return errors.Wrap(err, "unable to load original metadata")
 	}
@@ -88,6 +88,7 @@ func (s *IngestStep) Merge(dataset string, outputFolder string) error {
 	}
 
 	outputMeta := model.NewMetadata(meta.ID, meta.Name, meta.Description, meta.StorageName)
+	outputMeta.Schema = meta.Schema
 	outputMeta.DataResources = append(outputMeta.DataResources, model.NewDataResource("0", mainDR.ResType, mainDR.ResFormat))
 	header := rawResults[0]
 	for i, field := range header {
@@ -140,7 +141,7 @@ func (s *IngestStep) Merge(dataset string, outputFolder string) error {
 	outputMeta.DataResources[0].ResPath = relativePath
 
 	// write the new schema to file
-	err = metadata.WriteSchema(outputMeta, outputSchemaPath)
+	err = s.writeSchema(outputMeta, outputSchemaPath)
 	if err != nil {
 		return errors.Wrap(err, "unable to store merged schema")
 	}
@@ -34,6 +34,7 @@ import (
 	"github.com/uncharted-distil/distil-compute/primitive/compute"
 	"github.com/uncharted-distil/distil-compute/primitive/compute/description"
 	"github.com/uncharted-distil/distil-compute/primitive/compute/result"
+	"github.com/uncharted-distil/distil-ingest/metadata"
 	log "github.com/unchartedsoftware/plog"
 )
 
@@ -58,16 +59,41 @@ type FeatureRequest struct {
 
 // IngestStep is a step in the ingest process.
 type IngestStep struct {
-	client *compute.Client
+	client        *compute.Client
+	loadOptions   []metadata.LoadOption
+	schemaVersion string
 }
 
-// NewIngestStep creates a new ingest step.
-func NewIngestStep(client *compute.Client) *IngestStep {
+// NewIngestStep creates a new ingest step, loading the original schemas
+// with the options.
+func NewIngestStep(client *compute.Client, options ...metadata.LoadOption) *IngestStep {
 	return &IngestStep{
-		client: client,
+		client:      client,
+		loadOptions: options,
 	}
 }
 
+// SetSchemaVersion sets the version of the schemas written by the step,
+// which keep the version of the schema they were loaded from if empty.
+func (s *IngestStep) SetSchemaVersion(version string) error {
+	if version != "" {
+		err := metadata.CheckSchemaVersion(version)
+		if err != nil {
+			return err
+		}
+	}
+	s.schemaVersion = version
+	return nil
+}
+
+// writeSchema writes the schema of the metadata in the version of the step.
+func (s *IngestStep) writeSchema(meta *model.Metadata, path string) error {
+	if s.schemaVersion == "" {
+		return metadata.WriteSchema(meta, path)
+	}
+	return metadata.WriteSchemaVersion(meta, path, s.schemaVersion)
+}
+
 func (s *IngestStep) submitPrimitive(datasets []string, step *pipeline.PipelineDescription) (string, error) {
 
 	res, err := s.client.ExecutePipeline(context.Background(), datasets, step)